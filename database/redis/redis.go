@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -168,7 +169,7 @@ func PushNumber(key string, value int64, keep float32) bool {
 
 func GetNumber(key string) (int64, bool) {
 	var ctx = context.Background()
-	val, err := _instance.GetEx(ctx, key, 0).Int64()
+	val, err := _instance.Get(ctx, key).Int64()
 	if err != nil {
 		return 0, false
 	}
@@ -186,7 +187,7 @@ func PushString(key string, value string, keep float32) bool {
 
 func GetString(key string) (string, bool) {
 	var ctx = context.Background()
-	val, err := _instance.GetEx(ctx, key, 0).Result()
+	val, err := _instance.Get(ctx, key).Result()
 	if err != nil {
 		return "", false
 	}
@@ -230,9 +231,28 @@ func PushJson[T any](key string, values *T, keep float32, encoding bool) bool {
 	return true
 }
 
+// SET KEEPTTL, the expire time is not changed
+func UpdateJson[T any](key string, values *T, encoding bool) bool {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return false
+	}
+
+	var text = string(data)
+	if encoding {
+		text = base64.StdEncoding.EncodeToString(data)
+	}
+	var ctx = context.Background()
+	err = _instance.SetArgs(ctx, key, text, redis.SetArgs{KeepTTL: true}).Err()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
 func GetJson[T any](key string, value *T, encoding bool) bool {
 	var ctx = context.Background()
-	val, err := _instance.GetEx(ctx, key, 0).Result()
+	val, err := _instance.Get(ctx, key).Result()
 	if err != nil {
 		return false
 	}
@@ -264,7 +284,7 @@ func PushData(key string, values []byte, keep float32) bool {
 
 func GetData(key string) ([]byte, bool) {
 	var ctx = context.Background()
-	val, err := _instance.GetEx(ctx, key, 0).Result()
+	val, err := _instance.Get(ctx, key).Result()
 	if err != nil {
 		return nil, false
 	}
@@ -275,3 +295,122 @@ func GetData(key string) ([]byte, bool) {
 	}
 	return data, true
 }
+
+// HINCRBY of the fields (atomic), keep > 0 : expire time (seconds)
+func IncrFields(key string, values map[string]int64, keep float32) bool {
+	var ctx = context.Background()
+	pipe := _instance.TxPipeline()
+	for k, v := range values {
+		pipe.HIncrBy(ctx, key, k, v)
+	}
+	if keep > 0 {
+		pipe.Expire(ctx, key, keep_time(keep))
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// INCR of the number, the expire time (seconds) is set by the first increment
+func IncrNumber(key string, keep float32) (int64, bool) {
+	var ctx = context.Background()
+	val, err := _instance.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, false
+	}
+	if val == 1 && keep > 0 {
+		_instance.Expire(ctx, key, keep_time(keep))
+	}
+	return val, true
+}
+
+// Sliding window (sorted set of the request times), the server time is used by all instances
+// KEYS[1] : key, ARGV : window (ms), limit, member
+// Result : {allowed, count, reset (ms)}
+var sliding_window_script = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// Requests in the window, allowed : the request is counted
+func SlidingWindow(key string, limit int64, window time.Duration) (bool, int64, time.Duration, error) {
+	var ctx = context.Background()
+	var member = fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+	values, err := sliding_window_script.Run(ctx, _instance, []string{key},
+		window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return true, 0, 0, err
+	}
+	if len(values) != 3 {
+		return true, 0, 0, fmt.Errorf("sliding window result error")
+	}
+	return values[0] == 1, values[1], time.Duration(values[2]) * time.Millisecond, nil
+}
+
+func HasKey(key string) bool {
+	var ctx = context.Background()
+	val, err := _instance.Exists(ctx, key).Result()
+	if err != nil {
+		return false
+	}
+	return val > 0
+}
+
+// SET NX, false : the key exists
+func PushStringNX(key string, value string, keep float32) bool {
+	var ctx = context.Background()
+	ok, err := _instance.SetNX(ctx, key, value, keep_time(keep)).Result()
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+// Keys of the pattern (SCAN)
+func Keys(pattern string) []string {
+	var ctx = context.Background()
+	var keys = []string{}
+	iter := _instance.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys
+}
+
+func DelFields(key string, fields ...string) bool {
+	var ctx = context.Background()
+	err := _instance.HDel(ctx, key, fields...).Err()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// Count of the fields (HLEN)
+func CountFields(key string) (int64, bool) {
+	var ctx = context.Background()
+	val, err := _instance.HLen(ctx, key).Result()
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
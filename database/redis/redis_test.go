@@ -0,0 +1,61 @@
+package database_redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis of the tests (the keys are removed), there is no mock :
+// REDIS_TEST_ADDRESS=127.0.0.1:6379 go test ./database/redis
+func redis_test_instance(t *testing.T) {
+	var address = os.Getenv("REDIS_TEST_ADDRESS")
+	if len(address) == 0 {
+		t.Skip("REDIS_TEST_ADDRESS is not set")
+	}
+	var instance = redis.NewClient(&redis.Options{Addr: address, DialTimeout: 2 * time.Second})
+	if err := instance.Ping(context.Background()).Err(); err != nil {
+		instance.Close()
+		t.Skip("Redis (", address, ") is not available: ", err)
+	}
+	var previous = _instance
+	_instance = instance
+	t.Cleanup(func() {
+		_instance = previous
+		instance.Close()
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	redis_test_instance(t)
+
+	var key = fmt.Sprintf("ratelimit_test_%d", time.Now().UnixNano())
+	defer DelWithKey(key)
+	var window = 500 * time.Millisecond
+
+	for i := int64(1); i <= 3; i++ {
+		allowed, count, reset, err := SlidingWindow(key, 3, window)
+		if err != nil || !allowed || count != i || reset <= 0 || reset > window {
+			t.Fatalf("SlidingWindow(%d) = %v, %d, %v, %v", i, allowed, count, reset, err)
+		}
+	}
+
+	// The refused request is not counted
+	for i := 0; i < 2; i++ {
+		allowed, count, _, err := SlidingWindow(key, 3, window)
+		if err != nil || allowed || count != 3 {
+			t.Fatalf("SlidingWindow(over the limit) = %v, %d, %v", allowed, count, err)
+		}
+	}
+
+	// The window slides
+	time.Sleep(window + 50*time.Millisecond)
+	allowed, count, _, err := SlidingWindow(key, 3, window)
+	if err != nil || !allowed || count != 1 {
+		t.Fatalf("SlidingWindow(after the window) = %v, %d, %v", allowed, count, err)
+	}
+}
@@ -38,7 +38,7 @@ type HTTPClient2 struct {
 
 type HTTPData2 struct {
 	url        string
-	inner_url string
+	inner_url  string
 	Method     string
 	Headers    map[string]string
 	Timeout    float64
@@ -51,6 +51,7 @@ type HTTPData2 struct {
 	Content       any
 	ContentLength int
 	HasStream     bool
+	AllowText     bool // accept a non JSON response body
 
 	//Time
 	tick         int64
@@ -209,6 +210,9 @@ func (I *HTTPClient2) HTTPRequest2(path string, params map[string]any, data *HTT
 		switch data.Payload.(type) {
 		case string:
 			payload = data.Payload.(string)
+		case []byte:
+			// multipart/form-data or other raw body, set Content-Type in Headers
+			payload = string(data.Payload.([]byte))
 		case int16:
 		case int32:
 		case int8:
@@ -281,7 +285,7 @@ func (I *HTTPClient2) HTTPRequest2(path string, params map[string]any, data *HTT
 				}
 			default:
 				text, ok := val.(string)
-				if(!ok) {
+				if !ok {
 					text = ""
 				}
 				url = url + text
@@ -336,8 +340,9 @@ func (I *HTTPClient2) HTTPRequest2(path string, params map[string]any, data *HTT
 	data.Content = nil
 	data.ContentLength = 0
 
+	// Failed response is not a stream, read the error body
 	var result = -1
-	if data.HasStream {
+	if data.HasStream && response.StatusCode == http.StatusOK {
 		result = I.HTTPReadableStream2(response, data)
 	} else {
 		result = I.HTTPReadable2(response, data)
@@ -355,10 +360,15 @@ func (I *HTTPClient2) HTTPRequest2(path string, params map[string]any, data *HTT
 
 		data.ErrorCode = response.StatusCode
 		data.ErrorMessage = response.Status
+
+		// End of stream
+		if data.HasStream && data.CallbackStream != nil {
+			data.CallbackStream(0, nil, 0, data)
+		}
 		return nil
 	}
 
-	if data.ContentType != "json" {
+	if !data.HasStream && !data.AllowText && data.ContentType != "json" {
 		utils.Logger.LogError("(API) Response Body JSON Format Error: ", err)
 
 		data.ErrorCode = -2
@@ -529,6 +539,18 @@ func (I *HTTPClient2) HTTPReadableStream2Async(response *httpclient.Response, da
 		}
 		return -1
 	} else if count == 0 {
+		// Remaining data without line break (binary stream)
+		if len(chunk_data) > 0 {
+			chunk_length = len(chunk_data)
+			buffer = append(buffer, chunk_data...)
+			length += chunk_length
+
+			if data.CallbackStream != nil {
+				data.CallbackStream(index, &chunk_data, chunk_length, data)
+			}
+			index++
+		}
+
 		if data.CallbackStream != nil {
 			data.CallbackStream(0, nil, 0, data)
 		}
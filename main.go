@@ -1,19 +1,40 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/yaml.v3"
-	"mcmcx.com/gpt-server/httpx"
 	"mcmcx.com/gpt-server/server"
 	"mcmcx.com/gpt-server/utils"
 )
 
+// Password hash of the config (users), the password is read from stdin
+//
+//	echo -n "password" | gpt-server passwd
+func main_passwd() {
+	var reader = bufio.NewReader(os.Stdin)
+	password, _ := reader.ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) == 0 {
+		println("password is empty")
+		return
+	}
+	fmt.Println(utils.PasswordHash(password))
+}
+
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "passwd" {
+		main_passwd()
+		return
+	}
+
 	logger := utils.NewLogger()
 	logger.Init()
 
@@ -31,6 +52,10 @@ func main() {
 		return
 	}
 
+	if !server.ConfigInit(config) {
+		return
+	}
+
 	//
 	server.API_IPInit()
 	address, err := net.InterfaceAddrs()
@@ -47,14 +72,23 @@ func main() {
 		return
 	}
 
+	//Accounts
+	if !server.UserStore_Init(config.Users, config.Admins) {
+		return
+	}
+
+	//Signed access tokens
+	if !server.JWT_Init(config) {
+		return
+	}
+
 	//ChatGPT
 	if !server.API_GPTInit(config) {
 		return
 	}
 
 	//Get ai models
-	var data = server.API_GPTModels2()
-	if data.ErrorCode != httpx.HTTP_RESULT_OK || !server.OpenAI_Init(data.Data()) {
+	if !server.OpenAI_InitModels() {
 		logger.LogError("GPT Loading models failure.")
 		return
 	}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	API_KEY_PREFIX = "gs-"
+	API_KEY_MAX    = 20
+)
+
+// Scopes of the API keys and handlers (HandlerOptions.RequiredScopes)
+// The session tokens have all scopes, the API keys without scopes have all scopes except "account"
+const (
+	SCOPE_MODELS     = "models"
+	SCOPE_CHAT       = "chat"
+	SCOPE_EMBEDDINGS = "embeddings"
+	SCOPE_IMAGES     = "images"
+	SCOPE_AUDIO      = "audio"
+	SCOPE_USAGE      = "usage"
+	SCOPE_ACCOUNT    = "account"
+)
+
+var API_KEY_SCOPES = []string{SCOPE_MODELS, SCOPE_CHAT, SCOPE_EMBEDDINGS, SCOPE_IMAGES, SCOPE_AUDIO, SCOPE_USAGE, SCOPE_ACCOUNT}
+
+// API key (apikey_<sha256 of the key>), the key itself is not stored
+// The keys of the user : apikeys_user_<idx> (id -> hash)
+type DBAPIKeyData struct {
+	ID   string     `json:"id"`
+	IDX  utils.TIDX `json:"idx"`
+	Name string     `json:"name"`
+	// "gs-abcd...wxyz"
+	Mask   string   `json:"mask"`
+	Scopes []string `json:"scopes"`
+	// Model ids, a trailing '*' matches by prefix
+	Models       []string `json:"models"`
+	CreateTime   string   `json:"create_time"`
+	ExpireTime   string   `json:"expire_time"`
+	LastUsedTime string   `json:"last_used_time"`
+	// Unix time, 0 : never expires
+	Expires int64 `json:"expires"`
+}
+
+func db_apikey_id(hash string) string {
+	return fmt.Sprintf("apikey_%s", hash)
+}
+
+func db_apikeys_user_id(idx utils.TIDX) string {
+	return fmt.Sprintf("apikeys_user_%d", idx)
+}
+
+func api_key_hash(key string) string {
+	return strings.ToLower(utils.SHA256(key))
+}
+
+func IsAPIKey(text string) bool {
+	return strings.HasPrefix(text, API_KEY_PREFIX)
+}
+
+// New key, the key is returned only once
+func db_apikey_add(data *DBAPIKeyData, expires_in int64) (string, bool) {
+	var token = utils.GenerateToken()
+	if len(token) == 0 {
+		return "", false
+	}
+	var key = API_KEY_PREFIX + strings.ToLower(token)
+	var hash = api_key_hash(key)
+	var now = time.Now()
+
+	data.ID = hash[0:16]
+	data.Mask = key[0:7] + "..." + key[len(key)-4:]
+	data.CreateTime = utils.DateFormat(now, 3)
+	data.ExpireTime = ""
+	data.Expires = 0
+
+	var keep float32 = database_redis.KEEP_TIME
+	if expires_in > 0 {
+		var expires = now.Add(time.Duration(expires_in) * time.Second)
+		data.ExpireTime = utils.DateFormat(expires, 3)
+		data.Expires = expires.Unix()
+		keep = float32(expires_in)
+	}
+
+	if !database_redis.PushJson[DBAPIKeyData](db_apikey_id(hash), data, keep, false) {
+		return "", false
+	}
+	if !database_redis.PushFields(db_apikeys_user_id(data.IDX), map[string]string{data.ID: hash}) {
+		database_redis.DelWithKey(db_apikey_id(hash))
+		return "", false
+	}
+	return key, true
+}
+
+// Key of the Authorization, nil : invalidate or expiration
+func db_apikey_get(key string) *DBAPIKeyData {
+	var hash = api_key_hash(strings.TrimSpace(key))
+	var data DBAPIKeyData
+	if !database_redis.GetJson(db_apikey_id(hash), &data, false) {
+		return nil
+	}
+	if data.Expires > 0 && time.Now().Unix() >= data.Expires {
+		return nil
+	}
+	return &data
+}
+
+// Last used time, the expire time is not changed
+func db_apikey_used(key string, data *DBAPIKeyData) {
+	data.LastUsedTime = utils.DateFormat(time.Now(), 3)
+	database_redis.UpdateJson[DBAPIKeyData](db_apikey_id(api_key_hash(key)), data, false)
+}
+
+// Keys of the user, the expired keys are removed from the list
+func db_apikey_list(idx utils.TIDX) []*DBAPIKeyData {
+	var list = []*DBAPIKeyData{}
+	fields, ok := database_redis.GetFields(db_apikeys_user_id(idx))
+	if !ok {
+		return list
+	}
+
+	var expired = []string{}
+	for id, hash := range fields {
+		var data DBAPIKeyData
+		if !database_redis.GetJson(db_apikey_id(hash), &data, false) || data.IDX != idx {
+			expired = append(expired, id)
+			continue
+		}
+		list = append(list, &data)
+	}
+	if len(expired) > 0 {
+		database_redis.DelFields(db_apikeys_user_id(idx), expired...)
+	}
+	return list
+}
+
+func db_apikey_delete(idx utils.TIDX, id string) bool {
+	fields, ok := database_redis.GetFields(db_apikeys_user_id(idx))
+	if !ok {
+		return false
+	}
+	hash, ok := fields[id]
+	if !ok {
+		return false
+	}
+	database_redis.DelWithKey(db_apikey_id(hash))
+	database_redis.DelFields(db_apikeys_user_id(idx), id)
+	return true
+}
+
+// Remove all keys of the user (account disabled or deleted)
+func db_apikey_clear(idx utils.TIDX) int {
+	fields, ok := database_redis.GetFields(db_apikeys_user_id(idx))
+	if !ok {
+		return 0
+	}
+	for _, hash := range fields {
+		database_redis.DelWithKey(db_apikey_id(hash))
+	}
+	database_redis.DelWithKey(db_apikeys_user_id(idx))
+	return len(fields)
+}
+
+func (I *DBAPIKeyData) HasScope(scope string) bool {
+	if len(I.Scopes) == 0 {
+		return scope != SCOPE_ACCOUNT
+	}
+	for _, v := range I.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (I *DBAPIKeyData) AllowModel(model_id string) bool {
+	if len(I.Models) == 0 {
+		return true
+	}
+	for _, v := range I.Models {
+		if v == "*" || model_match(v, model_id) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,20 @@
 package server
 
+import "mcmcx.com/gpt-server/utils"
+
+// Service settings (InitServer)
+var server_config Config
+
+// The config is set once before the other initializations (main)
+func ConfigInit(config Config) bool {
+	server_config = config
+	return true
+}
+
 type Config struct {
 
 	//
-	MemoryMax		int		`yaml:"memory_max" json:"memory_max" validate:"-"` //32 << 20
+	MemoryMax int `yaml:"memory_max" json:"memory_max" validate:"-"` //32 << 20
 	// Server Settings:
 	IPv6             bool   `yaml:"ipv6" json:"ipv6" validate:"-"`
 	Address          string `yaml:"address" json:"address" validate:"-"`
@@ -14,11 +25,60 @@ type Config struct {
 	//
 	AllowDomains     bool     `yaml:"allow_domains" json:"allow_domains" validate:"-"`
 	AllowDomainsList []string `yaml:"allow_domains_list" json:"allow_domains_list" validate:"-"`
+	// Reverse proxies (IP or CIDR) trusted for X-Forwarded-For, default: none (the remote address is used)
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies" validate:"-"`
+	// Sliding window rate limits per route group (IP and IDX)
+	RateLimits []RateLimitConfig `yaml:"rate_limits" json:"rate_limits" validate:"-"`
+	// Anyone can register (/server/register), otherwise only the administrators
+	AllowRegister bool `yaml:"allow_register" json:"allow_register" validate:"-"`
+	// Access token and refresh token TTL (seconds, default: 1 day and 30 days, -1 : never expires)
+	TokenTTL        int `yaml:"token_ttl" json:"token_ttl" validate:"-"`
+	RefreshTokenTTL int `yaml:"refresh_token_ttl" json:"refresh_token_ttl" validate:"-"`
+	// Stateless access tokens (signed JWT, EdDSA or HS256), signing keys rotation (seconds, default: 7 days)
+	JWTEnabled   bool   `yaml:"jwt_enabled" json:"jwt_enabled" validate:"-"`
+	JWTAlgorithm string `yaml:"jwt_algorithm" json:"jwt_algorithm" validate:"-"`
+	JWTIssuer    string `yaml:"jwt_issuer" json:"jwt_issuer" validate:"-"`
+	JWTRotate    int    `yaml:"jwt_rotate" json:"jwt_rotate" validate:"-"`
+	// Accounts added on starting (password hash), failed logins before the lockout (seconds)
+	Users              []UserConfig `yaml:"users" json:"users" validate:"-"`
+	LoginMaxFailures   int          `yaml:"login_max_failures" json:"login_max_failures" validate:"-"`
+	LoginMaxFailuresIP int          `yaml:"login_max_failures_ip" json:"login_max_failures_ip" validate:"-"`
+	LoginLockout       int          `yaml:"login_lockout" json:"login_lockout" validate:"-"`
+	// Issuer of the two-factor authentication (otpauth uri, default: gpt-server)
+	TOTPIssuer string `yaml:"totp_issuer" json:"totp_issuer" validate:"-"`
+	// Single sign-on (OpenID Connect provider), empty issuer : disabled
+	OIDC OIDCConfig `yaml:"oidc" json:"oidc" validate:"-"`
+	// Administrator accounts (IDX)
+	Admins []utils.TIDX `yaml:"admins" json:"admins" validate:"-"`
+	// Public base url of /api/assets (default: server address and port)
+	AssetsUrl string `yaml:"assets_url" json:"assets_url" validate:"-"`
 
 	// API:
 	APIUrl          string `yaml:"openai_api_url" json:"openai_api_url" validate:"-"`
 	APIKey          string `yaml:"openai_api_key" json:"openai_api_key" validate:"-"`
 	APIOrganization string `yaml:"openai_api_org" json:"openai_api_org" validate:"-"`
+	// Upstream providers, default: openai_api_url, openai_api_key, openai_api_org
+	Upstreams []OpenAIUpstreamConfig `yaml:"openai_upstreams" json:"openai_upstreams" validate:"-"`
+	// Sampling parameters policy (per model)
+	ModelPolicies []OpenAIModelPolicy `yaml:"openai_model_policies" json:"openai_model_policies" validate:"-"`
+	// Tokenizer vocabulary (cl100k_base.tiktoken)
+	TokenizerFile string `yaml:"tokenizer_file" json:"tokenizer_file" validate:"-"`
+	// Fallback chains (per model) on upstream 5xx or timeout
+	ModelFallbacks map[string][]string `yaml:"openai_model_fallbacks" json:"openai_model_fallbacks" validate:"-"`
+	// Prices (per model, USD per 1K tokens) of the usage cost
+	ModelPrices []OpenAIModelPrice `yaml:"openai_model_prices" json:"openai_model_prices" validate:"-"`
+	// Responses cache of the deterministic chat requests (temperature 0) TTL (seconds, 0: disabled)
+	CompletionCacheTTL int `yaml:"completion_cache_ttl" json:"completion_cache_ttl" validate:"-"`
+	// Conversations (conversation_id of the chat requests) TTL (seconds, default: never expires)
+	ConversationTTL int `yaml:"conversation_ttl" json:"conversation_ttl" validate:"-"`
+	// Prompt templates (prompt_template of the chat requests), the YAML files of the directory (one template per file)
+	PromptTemplates    []PromptTemplate `yaml:"prompt_templates" json:"prompt_templates" validate:"-"`
+	PromptTemplatesDir string           `yaml:"prompt_templates_dir" json:"prompt_templates_dir" validate:"-"`
+	// Mandatory system prefixes per group ("*", "role:<role>", "plan:<plan>")
+	PromptPrefixes map[string]string `yaml:"prompt_prefixes" json:"prompt_prefixes" validate:"-"`
+	// User plans (quotas), the default plan of users without a plan (empty: unlimited)
+	Plans       []UserPlan `yaml:"user_plans" json:"user_plans" validate:"-"`
+	DefaultPlan string     `yaml:"user_default_plan" json:"user_default_plan" validate:"-"`
 	//
 	//IntervalSeconds int    `yaml:"intervalSeconds" json:"intervalSeconds" bson:"intervalSeconds" validate:"required"`
 	//Model           string `yaml:"model" json:"model" bson:"model" validate:"required"`
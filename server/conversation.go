@@ -0,0 +1,264 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// conversation_id of the chat request, a new conversation is created
+	CONVERSATION_NEW          = "new"
+	CONVERSATION_MAX          = 1000
+	CONVERSATION_MESSAGES_MAX = 1000
+	CONVERSATION_TITLE_MAX    = 64
+	// Response header of the conversation id
+	CONVERSATION_ID_HEADER = "X-Conversation-Id"
+	// Lock of the conversation saving (seconds), waiting time (milliseconds)
+	CONVERSATION_LOCK_KEEP = 10
+	CONVERSATION_LOCK_WAIT = 5000
+)
+
+// Conversation (conversation_<idx>_<id>), the conversations of the user : conversations_user_<idx> (id -> update time)
+type DBConversation struct {
+	ID    string     `json:"id"`
+	IDX   utils.TIDX `json:"idx"`
+	Title string     `json:"title"`
+	Model string     `json:"model"`
+	// OpenAI messages (role, content, ...)
+	Messages   []any  `json:"messages"`
+	CreateTime string `json:"create_time"`
+	UpdateTime string `json:"update_time"`
+	// Loaded from the database (not a new conversation)
+	exists bool
+}
+
+func db_conversation_id(idx utils.TIDX, id string) string {
+	return fmt.Sprintf("conversation_%d_%s", idx, id)
+}
+
+func db_conversations_user_id(idx utils.TIDX) string {
+	return fmt.Sprintf("conversations_user_%d", idx)
+}
+
+func db_conversation_lock_id(idx utils.TIDX, id string) string {
+	return fmt.Sprintf("conversation_lock_%d_%s", idx, id)
+}
+
+// The saving of the conversation is serialized (conversation_lock_<idx>_<id>), false : timeout
+func db_conversation_lock(idx utils.TIDX, id string) bool {
+	for i := 0; i < CONVERSATION_LOCK_WAIT/50; i++ {
+		if database_redis.PushStringNX(db_conversation_lock_id(idx, id), "1", CONVERSATION_LOCK_KEEP) {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+func db_conversation_unlock(idx utils.TIDX, id string) {
+	database_redis.DelWithKey(db_conversation_lock_id(idx, id))
+}
+
+// Seconds, 0 : never expires
+func conversation_keep() float32 {
+	if server_config.ConversationTTL > 0 {
+		return float32(server_config.ConversationTTL)
+	}
+	return database_redis.KEEP_TIME
+}
+
+func db_conversation_get(idx utils.TIDX, id string) *DBConversation {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !utils.CheckToken(id) {
+		return nil
+	}
+	var data DBConversation
+	if !database_redis.GetJson(db_conversation_id(idx, id), &data, false) || data.IDX != idx {
+		return nil
+	}
+	if data.Messages == nil {
+		data.Messages = []any{}
+	}
+	data.exists = true
+	return &data
+}
+
+func db_conversation_save(data *DBConversation) bool {
+	data.UpdateTime = utils.DateFormat(time.Now(), 3)
+	if len(data.Messages) > CONVERSATION_MESSAGES_MAX {
+		data.Messages = data.Messages[len(data.Messages)-CONVERSATION_MESSAGES_MAX:]
+	}
+	if !database_redis.PushJson[DBConversation](db_conversation_id(data.IDX, data.ID), data, conversation_keep(), false) {
+		return false
+	}
+	return database_redis.PushFields(db_conversations_user_id(data.IDX), map[string]string{data.ID: data.UpdateTime})
+}
+
+// Conversations of the user (without messages), the expired conversations are removed from the list
+func db_conversation_list(idx utils.TIDX) []*DBConversation {
+	var list = []*DBConversation{}
+	fields, ok := database_redis.GetFields(db_conversations_user_id(idx))
+	if !ok {
+		return list
+	}
+
+	var expired = []string{}
+	for id := range fields {
+		var data = db_conversation_get(idx, id)
+		if data == nil {
+			expired = append(expired, id)
+			continue
+		}
+		list = append(list, data)
+	}
+	if len(expired) > 0 {
+		database_redis.DelFields(db_conversations_user_id(idx), expired...)
+	}
+	return list
+}
+
+// Count of the conversations (HLEN), the expired conversations are removed over the limit
+func db_conversation_count(idx utils.TIDX) int {
+	count, _ := database_redis.CountFields(db_conversations_user_id(idx))
+	if count < CONVERSATION_MAX {
+		return int(count)
+	}
+
+	fields, ok := database_redis.GetFields(db_conversations_user_id(idx))
+	if !ok {
+		return int(count)
+	}
+	var expired = []string{}
+	for id := range fields {
+		if !database_redis.HasKey(db_conversation_id(idx, id)) {
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) > 0 {
+		database_redis.DelFields(db_conversations_user_id(idx), expired...)
+	}
+	return len(fields) - len(expired)
+}
+
+func db_conversation_delete(idx utils.TIDX, id string) bool {
+	if db_conversation_get(idx, id) == nil {
+		return false
+	}
+	database_redis.DelFields(db_conversations_user_id(idx), id)
+	return database_redis.DelWithKey(db_conversation_id(idx, id))
+}
+
+// Remove all conversations of the user (account deleted)
+func db_conversation_clear(idx utils.TIDX) int {
+	fields, ok := database_redis.GetFields(db_conversations_user_id(idx))
+	if !ok {
+		return 0
+	}
+	for id := range fields {
+		database_redis.DelWithKey(db_conversation_id(idx, id))
+	}
+	database_redis.DelWithKey(db_conversations_user_id(idx))
+	return len(fields)
+}
+
+// Title of the first user message
+func conversation_title(messages []any) string {
+	for _, v := range messages {
+		message, ok := v.(map[string]any)
+		if !ok || message["role"] != "user" {
+			continue
+		}
+		text, _ := openai_message_content(message["content"])
+		text = strings.Join(strings.Fields(text), " ")
+		if len([]rune(text)) > CONVERSATION_TITLE_MAX {
+			text = string([]rune(text)[0:CONVERSATION_TITLE_MAX])
+		}
+		return text
+	}
+	return ""
+}
+
+// Conversation of the chat request (conversation_id), the prior messages are added before the request messages
+// The conversation_id is removed from the body, nil : not a conversation request
+func OpenAI_ConversationLoad(body map[string]any, idx utils.TIDX, model_id string) (*DBConversation, []any, *OpenAIError) {
+	value, ok := body["conversation_id"]
+	if !ok {
+		return nil, nil, nil
+	}
+	delete(body, "conversation_id")
+
+	id, _ := value.(string)
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) == 0 {
+		return nil, nil, nil
+	}
+
+	messages, ok := body["messages"].([]any)
+	if !ok || len(messages) == 0 {
+		return nil, nil, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "messages",
+			"'messages' is a required property")
+	}
+
+	var conversation *DBConversation = nil
+	if id == CONVERSATION_NEW {
+		if db_conversation_count(idx) >= CONVERSATION_MAX {
+			return nil, nil, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "conversation_id",
+				"Too many conversations, delete the previous conversations.")
+		}
+		conversation = &DBConversation{
+			ID:         strings.ToLower(utils.GenerateToken()[0:32]),
+			IDX:        idx,
+			Title:      conversation_title(messages),
+			Messages:   []any{},
+			CreateTime: utils.DateFormat(time.Now(), 3),
+		}
+	} else {
+		conversation = db_conversation_get(idx, id)
+		if conversation == nil {
+			var err = NewOpenAIError(http.StatusNotFound, "invalid_request_error", "conversation_id",
+				fmt.Sprintf("The conversation '%s' does not exist", id))
+			err.Code = "conversation_not_found"
+			return nil, nil, err
+		}
+	}
+	conversation.Model = model_id
+
+	var payload = make([]any, 0, len(conversation.Messages)+len(messages))
+	payload = append(payload, conversation.Messages...)
+	payload = append(payload, messages...)
+	body["messages"] = payload
+	return conversation, messages, nil
+}
+
+// The request messages and the reply are appended to the saved messages,
+// the messages of the concurrent requests are kept, a deleted conversation is not saved again
+func (I *DBConversation) Append(messages []any, reply string) bool {
+	if !db_conversation_lock(I.IDX, I.ID) {
+		return false
+	}
+	defer db_conversation_unlock(I.IDX, I.ID)
+
+	if data := db_conversation_get(I.IDX, I.ID); data != nil {
+		I.Title = data.Title
+		I.Messages = data.Messages
+	} else if I.exists {
+		return false
+	}
+
+	I.Messages = append(I.Messages, messages...)
+	if len(reply) > 0 {
+		I.Messages = append(I.Messages, map[string]any{
+			"role":    "assistant",
+			"content": reply,
+		})
+	}
+	if len(I.Title) == 0 {
+		I.Title = conversation_title(I.Messages)
+	}
+	return db_conversation_save(I)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	TOTP_ISSUER         = "gpt-server"
+	TOTP_RECOVERY_CODES = 10
+)
+
+// pass : password of the setup and disable, code : TOTP code or recovery code
+type T2FAData struct {
+	Password string `form:"pass" json:"pass"`
+	Code     string `form:"code" json:"code"`
+}
+
+var Error2FARequired = errors.New("two-factor authentication required")
+var Error2FAFailed = errors.New("two-factor authentication code invalidate")
+var Error2FAEnabled = errors.New("two-factor authentication already enabled")
+var Error2FADisabled = errors.New("two-factor authentication not enabled")
+
+func totp_issuer() string {
+	if len(server_config.TOTPIssuer) > 0 {
+		return server_config.TOTPIssuer
+	}
+	return TOTP_ISSUER
+}
+
+func totp_recovery_hash(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return strings.ToLower(utils.SHA256(code))
+}
+
+func (I *DBUserData) HasTOTP() bool {
+	return I != nil && len(I.TOTPSecret) > 0
+}
+
+// TOTP code or recovery code, the used step or recovery code is saved
+func (I *DBUserData) VerifyTOTP(code string) bool {
+	if !I.HasTOTP() || len(strings.TrimSpace(code)) == 0 {
+		return false
+	}
+	if step := utils.TOTPVerify(I.TOTPSecret, code, I.TOTPStep); step > 0 {
+		I.TOTPStep = step
+		return db_user_save(I)
+	}
+
+	var hash = totp_recovery_hash(code)
+	for i, v := range I.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(hash)) == 1 {
+			I.RecoveryCodes = slices.Delete(I.RecoveryCodes, i, i+1)
+			utils.Logger.LogWarning("[2FA] IDX:", I.IDX, " Recovery code used (", len(I.RecoveryCodes), " left)")
+			return db_user_save(I)
+		}
+	}
+	return false
+}
+
+// New recovery codes ("xxxxx-xxxxx"), the codes are returned once
+func (I *DBUserData) recovery_codes_new() []string {
+	var codes = []string{}
+	I.RecoveryCodes = []string{}
+	for i := 0; i < TOTP_RECOVERY_CODES; i++ {
+		var token = strings.ToLower(utils.GenerateToken())
+		if len(token) == 0 {
+			return nil
+		}
+		var code = token[0:5] + "-" + token[5:10]
+		codes = append(codes, code)
+		I.RecoveryCodes = append(I.RecoveryCodes, totp_recovery_hash(code))
+	}
+	return codes
+}
+
+func handle_2fa_data(ctx *gin.Context, handler *Handler) (*DBUserData, *T2FAData) {
+	var data T2FAData = T2FAData{}
+	if err := handler.GetData(&data); err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return nil, nil
+	}
+
+	var user = db_user_get(handler.AuthorizationData.IDX)
+	if user == nil {
+		HandleResultFailed(ctx, -101, ErrorAccountNotFound.Error())
+		return nil, nil
+	}
+	if login_locked(user.IDX, handler.RemoteAddress) {
+		HandleResultFailed(ctx, -103, ErrorLoginLocked.Error())
+		return nil, nil
+	}
+	return user, &data
+}
+
+// New secret (pass), enabled by /server/2fa/verify : otpauth uri of the authenticator
+func HandleUser2FASetup(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	user, data := handle_2fa_data(ctx, handler)
+	if user == nil {
+		return
+	}
+	if !user.Verify(data.Password) {
+		login_failed(user.IDX, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, ErrorLoginFailed.Error())
+		return
+	}
+	if user.HasTOTP() {
+		HandleResultFailed(ctx, -102, Error2FAEnabled.Error())
+		return
+	}
+
+	user.TOTPPending = utils.TOTPGenerateSecret()
+	if len(user.TOTPPending) == 0 || !db_user_save(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"secret": user.TOTPPending,
+		"uri":    utils.TOTPURI(totp_issuer(), user.Name, user.TOTPPending),
+	})
+}
+
+// Enable the pending secret (code), the recovery codes are returned once
+func HandleUser2FAVerify(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	user, data := handle_2fa_data(ctx, handler)
+	if user == nil {
+		return
+	}
+	if user.HasTOTP() {
+		HandleResultFailed(ctx, -102, Error2FAEnabled.Error())
+		return
+	}
+	if len(user.TOTPPending) == 0 {
+		HandleResultFailed(ctx, -102, Error2FADisabled.Error())
+		return
+	}
+
+	var step = utils.TOTPVerify(user.TOTPPending, data.Code, 0)
+	if step < 0 {
+		login_failed(user.IDX, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, Error2FAFailed.Error())
+		return
+	}
+
+	user.TOTPSecret = user.TOTPPending
+	user.TOTPPending = ""
+	user.TOTPStep = step
+	var codes = user.recovery_codes_new()
+	if codes == nil || !db_user_save(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[2FA] IDX:", user.IDX, " Enabled IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"enabled":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// New recovery codes (code), the previous codes are invalidated
+func HandleUser2FARecovery(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	user, data := handle_2fa_data(ctx, handler)
+	if user == nil {
+		return
+	}
+	if !user.HasTOTP() {
+		HandleResultFailed(ctx, -102, Error2FADisabled.Error())
+		return
+	}
+	if !user.VerifyTOTP(data.Code) {
+		login_failed(user.IDX, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, Error2FAFailed.Error())
+		return
+	}
+
+	var codes = user.recovery_codes_new()
+	if codes == nil || !db_user_save(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[2FA] IDX:", user.IDX, " Recovery codes IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// Disable the two-factor authentication (pass, code)
+func HandleUser2FADisable(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	user, data := handle_2fa_data(ctx, handler)
+	if user == nil {
+		return
+	}
+	if !user.HasTOTP() {
+		HandleResultFailed(ctx, -102, Error2FADisabled.Error())
+		return
+	}
+	if !user.Verify(data.Password) || !user.VerifyTOTP(data.Code) {
+		login_failed(user.IDX, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, Error2FAFailed.Error())
+		return
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPPending = ""
+	user.TOTPStep = 0
+	user.RecoveryCodes = []string{}
+	if !db_user_save(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[2FA] IDX:", user.IDX, " Disabled IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"enabled": false,
+	})
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	USER_NAME_MIN     = 3
+	USER_NAME_MAX     = 32
+	USER_PASSWORD_MIN = 8
+	USER_PASSWORD_MAX = 128
+)
+
+type TRegisterData struct {
+	Username string `form:"name" json:"name"`
+	Password string `form:"pass" json:"pass"`
+}
+
+type TRegisterResultData struct {
+	IDX        utils.TIDX `json:"idx"`
+	Username   string     `json:"name"`
+	CreateTime string     `json:"create_time"`
+}
+
+type TPasswordData struct {
+	Password    string `form:"pass" json:"pass"`
+	NewPassword string `form:"new_pass" json:"new_pass"`
+}
+
+type TAdminUserData struct {
+	IDX utils.TIDX `form:"idx" json:"idx"`
+	// "disable", "enable" or "role"
+	Action string `form:"action" json:"action"`
+	Role   string `form:"role" json:"role"`
+}
+
+var ErrorAccountName = errors.New("account name invalidate")
+var ErrorAccountNameExists = errors.New("account name already exists")
+var ErrorAccountPassword = errors.New("password invalidate")
+var ErrorAccountNotFound = errors.New("account not found")
+
+var user_name_regex = regexp.MustCompile("^[0-9a-zA-Z_.-]+$")
+
+func user_name_check(name string) bool {
+	return len(name) >= USER_NAME_MIN && len(name) <= USER_NAME_MAX && user_name_regex.MatchString(name)
+}
+
+func user_password_check(password string) bool {
+	return len(password) >= USER_PASSWORD_MIN && len(password) <= USER_PASSWORD_MAX
+}
+
+// Account information
+func user_account_data(user *DBUserData) gin.H {
+	var plan = ""
+	if value := UserPlan_Get(user.IDX); value != nil {
+		plan = value.Name
+	}
+	return gin.H{
+		"idx":         user.IDX,
+		"name":        user.Name,
+		"status":      user.Status,
+		"plan":        plan,
+		"role":        user.Role,
+		"admin":       user.Role == ROLE_ADMIN,
+		"two_factor":  user.HasTOTP(),
+		"create_time": user.CreateTime,
+		"update_time": user.UpdateTime,
+	}
+}
+
+// New account (name, pass), only the administrators can register when allow_register is off
+func HandleUserRegister(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: !server_config.AllowRegister})
+	if result < 0 {
+		return
+	}
+
+	if !server_config.AllowRegister && !handler.IsAdmin() {
+		HandleResultError(ctx, -100, "permission denied")
+		return
+	}
+
+	var register_data TRegisterData = TRegisterData{}
+	if err := handler.GetData(&register_data); err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return
+	}
+
+	register_data.Username = strings.TrimSpace(register_data.Username)
+	if !user_name_check(register_data.Username) {
+		HandleResultFailed(ctx, -101, ErrorAccountName.Error())
+		return
+	}
+	if !user_password_check(register_data.Password) {
+		HandleResultFailed(ctx, -102, ErrorAccountPassword.Error())
+		return
+	}
+
+	// Account number
+	var idx utils.TIDX = 0
+	for i := 0; i < 10; i++ {
+		var value = utils.GenerateIDX(0)
+		if !database_redis.HasKey(db_user_id(value)) {
+			idx = value
+			break
+		}
+	}
+	if idx == 0 {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	// The name is reserved first
+	if !database_redis.PushStringNX(db_user_name_id(register_data.Username), fmt.Sprintf("%d", idx), database_redis.KEEP_TIME) {
+		HandleResultFailed(ctx, -103, ErrorAccountNameExists.Error())
+		return
+	}
+
+	var user = &DBUserData{
+		IDX:        idx,
+		Name:       register_data.Username,
+		Password:   utils.PasswordHash(register_data.Password),
+		Status:     USER_STATUS_ACTIVE,
+		Role:       ROLE_MEMBER,
+		CreateTime: utils.DateFormat(time.Now(), 3),
+	}
+	if len(user.Password) == 0 || !db_user_save(user) {
+		database_redis.DelWithKey(db_user_name_id(register_data.Username))
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[Account] Register IDX:", user.IDX, " Username:", user.Name,
+		" IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, TRegisterResultData{
+		IDX:        user.IDX,
+		Username:   user.Name,
+		CreateTime: user.CreateTime,
+	})
+}
+
+// Change the password (pass, new_pass), the tokens of the other devices are invalidated
+func HandleUserPassword(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var password_data TPasswordData = TPasswordData{}
+	if err := handler.GetData(&password_data); err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return
+	}
+
+	// Too many failed attempts (IDX or IP), the current password is not guessed with a stolen token
+	var idx = handler.AuthorizationData.IDX
+	if login_locked(idx, handler.RemoteAddress) {
+		utils.Logger.LogWarning("[Account] Password IDX:", idx, " locked IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -103, ErrorLoginLocked.Error())
+		return
+	}
+	var user = db_user_get(idx)
+	if !user.Verify(password_data.Password) {
+		login_failed(idx, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, ErrorLoginFailed.Error())
+		return
+	}
+	login_succeeded(idx)
+	if !user_password_check(password_data.NewPassword) {
+		HandleResultFailed(ctx, -102, ErrorAccountPassword.Error())
+		return
+	}
+
+	user.Password = utils.PasswordHash(password_data.NewPassword)
+	if len(user.Password) == 0 || !db_user_save(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	var count = db_login_data_clear(idx, handler.AuthorizationData.AuthToken)
+	utils.Logger.LogWarning("[Account] Password IDX:", idx, " Devices (", count, ") signed out",
+		" IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, user_account_data(user))
+}
+
+// Account information (GET), delete the account (DELETE : pass in the body, not in the url)
+func HandleUserAccount(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var user = db_user_get(idx)
+	if user == nil {
+		HandleResultFailed(ctx, -101, ErrorAccountNotFound.Error())
+		return
+	}
+
+	if handler.Method != http.MethodDelete {
+		ctx.JSON(http.StatusOK, user_account_data(user))
+		return
+	}
+
+	var password_data TPasswordData = TPasswordData{}
+	if err := handler.GetData(&password_data); err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return
+	}
+	// Too many failed attempts (IDX or IP)
+	if login_locked(idx, handler.RemoteAddress) {
+		utils.Logger.LogWarning("[Account] Delete IDX:", idx, " locked IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -103, ErrorLoginLocked.Error())
+		return
+	}
+	if !user.Verify(password_data.Password) {
+		login_failed(idx, handler.RemoteAddress)
+		HandleResultFailed(ctx, -102, ErrorLoginFailed.Error())
+		return
+	}
+	login_succeeded(idx)
+
+	db_login_data_clear(idx, "")
+	db_apikey_clear(idx)
+	db_conversation_clear(idx)
+	if !db_user_delete(user) {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[Account] Delete IDX:", idx, " Username:", user.Name,
+		" IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"idx":     idx,
+		"deleted": true,
+	})
+}
+
+// Accounts (GET), disable or enable the account, change the role (POST : idx, action, role)
+// The tokens of the account are cleared when disabled or the role is changed
+func HandleAdminUsers(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true,
+		RequiredRoles: []string{ROLE_ADMIN}, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	if handler.Method == http.MethodPost {
+		var user_data TAdminUserData = TAdminUserData{}
+		if err := handler.GetData(&user_data); err != nil {
+			HandleResultFailed(ctx, -101, err.Error())
+			return
+		}
+
+		var user = db_user_get(user_data.IDX)
+		if user == nil {
+			HandleResultFailed(ctx, -102, ErrorAccountNotFound.Error())
+			return
+		}
+
+		var count = 0
+		switch strings.ToLower(strings.TrimSpace(user_data.Action)) {
+		case "disable":
+			user.Status = USER_STATUS_DISABLED
+			count = db_login_data_clear(user.IDX, "")
+		case "enable":
+			user.Status = USER_STATUS_ACTIVE
+		case "role":
+			var role = strings.ToLower(strings.TrimSpace(user_data.Role))
+			if !IsUserRole(role) {
+				HandleResultFailed(ctx, -103, "role invalidate")
+				return
+			}
+			user.Role = role
+			count = db_login_data_clear(user.IDX, "")
+		default:
+			HandleResultFailed(ctx, -103, "action invalidate")
+			return
+		}
+		if !db_user_save(user) {
+			HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+			return
+		}
+
+		utils.Logger.LogWarning("[Account] ", user.Status, " IDX:", user.IDX, " Role:", user.Role, " Devices (", count, ") signed out",
+			" by ", handler.AuthorizationData.IDX)
+		ctx.JSON(http.StatusOK, user_account_data(user))
+		return
+	}
+
+	var users = db_user_list()
+	sort.Slice(users, func(i, j int) bool { return users[i].IDX < users[j].IDX })
+
+	var list = []gin.H{}
+	for _, v := range users {
+		list = append(list, user_account_data(v))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   list,
+	})
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+// Upstreams and API keys health
+func HandleAdminUpstreams(ctx *gin.Context) {
+	result, _ := InitHandler(ctx, &HandlerOptions{HasAuthorization: true,
+		RequiredRoles: []string{ROLE_ADMIN}, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var list = []gin.H{}
+	for _, v := range aiapi_upstreams {
+		list = append(list, gin.H{
+			"name":          v.Name,
+			"type":          v.Type,
+			"url":           v.Config.Url,
+			"models":        v.Config.Models,
+			"key_selection": v.Keys.Selection,
+			"key_cooldown":  v.Keys.Cooldown.Seconds(),
+			"keys":          v.Keys.Health(),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   list,
+	})
+}
+
+type TAdminPlanData struct {
+	IDX  utils.TIDX `form:"idx" json:"idx"`
+	Plan string     `form:"plan" json:"plan"`
+}
+
+// Plans (GET), set the plan of the user (POST : idx, plan)
+func HandleAdminPlans(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true,
+		RequiredRoles: []string{ROLE_ADMIN}, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	if handler.Method == http.MethodPost {
+		var plan_data TAdminPlanData = TAdminPlanData{}
+		if err := handler.GetData(&plan_data); err != nil {
+			HandleResultFailed(ctx, -101, err.Error())
+			return
+		}
+		if !utils.CheckAccountIDX(plan_data.IDX, 6, 12) {
+			HandleResultFailed(ctx, -102, "account invalidate")
+			return
+		}
+		var plan = UserPlan_Find(plan_data.Plan)
+		if plan == nil {
+			HandleResultFailed(ctx, -103, "plan not found")
+			return
+		}
+		if !UserPlan_Set(plan_data.IDX, plan.Name) {
+			HandleResultFailed(ctx, -104, "plan saving failure")
+			return
+		}
+		utils.Logger.LogWarning("[Plan] IDX:", plan_data.IDX, " Plan (", plan.Name, ") by ", handler.AuthorizationData.IDX)
+	}
+
+	var list = []*UserPlan{}
+	for _, v := range user_plans {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object":  "list",
+		"default": user_default_plan,
+		"data":    list,
+	})
+}
+
+type TAdminPromptData struct {
+	Action      string            `form:"action" json:"action"`
+	Name        string            `form:"name" json:"name"`
+	Description string            `form:"description" json:"description"`
+	Content     string            `form:"content" json:"content"`
+	Variables   map[string]string `form:"variables" json:"variables"`
+	Group       string            `form:"group" json:"group"`
+}
+
+// Prompt templates and system prefixes (GET)
+// Save or delete the template (POST : action "template" or "delete", name, description, content, variables),
+// set the prefix of the group (POST : action "prefix", group, content), empty content : removed
+func HandleAdminPrompts(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true,
+		RequiredRoles: []string{ROLE_ADMIN}, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	if handler.Method == http.MethodPost {
+		var prompt_data TAdminPromptData = TAdminPromptData{}
+		if err := handler.GetData(&prompt_data); err != nil {
+			HandleResultFailed(ctx, -101, err.Error())
+			return
+		}
+		if len(prompt_data.Content) > PROMPT_CONTENT_MAX {
+			HandleResultFailed(ctx, -102, "content invalidate")
+			return
+		}
+
+		var name = strings.ToLower(strings.TrimSpace(prompt_data.Name))
+		switch strings.ToLower(strings.TrimSpace(prompt_data.Action)) {
+		case "template":
+			if !prompt_name_check(name) || len(strings.TrimSpace(prompt_data.Content)) == 0 {
+				HandleResultFailed(ctx, -102, "template invalidate")
+				return
+			}
+			var template = &PromptTemplate{
+				Name:        name,
+				Description: prompt_data.Description,
+				Content:     prompt_data.Content,
+				Variables:   prompt_data.Variables,
+			}
+			if !db_prompt_template_save(template) {
+				HandleResultFailed(ctx, -104, "template saving failure")
+				return
+			}
+			utils.Logger.LogWarning("[Prompt] Template (", name, ") saved by ", handler.AuthorizationData.IDX)
+		case "delete":
+			if !prompt_name_check(name) || !db_prompt_template_delete(name) {
+				HandleResultFailed(ctx, -103, "template not found")
+				return
+			}
+			utils.Logger.LogWarning("[Prompt] Template (", name, ") deleted by ", handler.AuthorizationData.IDX)
+		case "prefix":
+			var group = prompt_group(prompt_data.Group)
+			if len(group) == 0 {
+				HandleResultFailed(ctx, -102, "group invalidate")
+				return
+			}
+			if !database_redis.PushFields(DB_PROMPT_PREFIXES_ID, map[string]string{group: prompt_data.Content}) {
+				HandleResultFailed(ctx, -104, "prefix saving failure")
+				return
+			}
+			utils.Logger.LogWarning("[Prompt] Prefix (", group, ") saved by ", handler.AuthorizationData.IDX)
+		default:
+			HandleResultFailed(ctx, -103, "action invalidate")
+			return
+		}
+	}
+
+	var list = []*PromptTemplate{}
+	for _, v := range Prompt_Templates() {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     list,
+		"prefixes": Prompt_Prefixes(),
+	})
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"mcmcx.com/gpt-server/httpx"
@@ -39,6 +41,20 @@ func OpenAI_Init(models any) bool {
 	return true
 }
 
+// Unknown models are rejected, the upstream is picked by the model
+func openai_model_check(ctx *gin.Context, model_id string) (*OPENAI_MODEL_ITEM, *OpenAIUpstream) {
+	var item = OPENAI_Models.Find(model_id)
+	var upstream = OpenAI_Upstream(model_id)
+	if item == nil || upstream == nil {
+		var err = NewOpenAIError(http.StatusNotFound, "invalid_request_error", "model",
+			fmt.Sprintf("The model '%s' does not exist", model_id))
+		err.Code = "model_not_found"
+		HandleResultOpenAIError(ctx, err)
+		return nil, nil
+	}
+	return item, upstream
+}
+
 func HandleOpenAIModels(ctx *gin.Context) {
 	result, _ := InitHandler(ctx, &HandlerOptions{HasAuthorization: false})
 	if result < 0 {
@@ -88,18 +104,9 @@ func HandleOpenAIModels(ctx *gin.Context) {
 // 	   "stop": null,
 // 	   "user": id,
 //   }'
-// curl https://api.openai.com/v1/images/generations \
-//   -H "Content-Type: application/json" \
-//   -H "Authorization: Bearer $OPENAI_API_KEY" \
-//   -d '{
-//     "model": "dall-e-3",
-//     "prompt": "a white siamese cat",
-//     "n": 1,
-//     "size": "1024x1024"
-//   }'
 
 func HandleOpenAICompletions(ctx *gin.Context) {
-	result, handler := InitHandler(ctx, &HandlerOptions{PrintHeaders: true, DataType: "json", HasAuthorization: true})
+	result, handler := InitHandler(ctx, &HandlerOptions{PrintHeaders: true, DataType: "json", HasAuthorization: true, HasQuota: true, RequiredScopes: []string{SCOPE_CHAT}})
 	if result < 0 {
 		return
 	}
@@ -110,89 +117,249 @@ func HandleOpenAICompletions(ctx *gin.Context) {
 		return
 	}
 
-	//
-	body["temperature"] = 1
-	body["top_p"] = 1
-	body["presence_penalty"] = 0
-	body["frequency_penalty"] = 0
-	body["stream"] = true
-	body["stop"] = nil
+	// Default (OpenAI) : false
+	stream, ok := body["stream"].(bool)
+	if !ok {
+		stream = false
+	}
+	body["stream"] = stream
+
+	// The policy defaults fill the missing temperature
+	var deterministic = OpenAI_CacheDeterministic(body)
 
 	id, ok := body["user"].(string)
-	if(!ok) {
+	if !ok {
 		id = "id0000"
 	}
 	id = strings.ToLower(strings.TrimSpace(id))
 
 	// Checking models
-	// ChatGPT-3 : 4096 tokens
-	body["max_tokens"] = 2048
 	model_id, ok := body["model"].(string)
-	if !ok {
-		model_id = "gpt-3.5-turbo"
+	if !ok || len(strings.TrimSpace(model_id)) == 0 {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "model",
+			"'model' is a required property"))
+		return
 	}
 
 	model_id = strings.ToLower(strings.TrimSpace(model_id))
-	var item = OPENAI_Models.Find(model_id)
-	if(item == nil) {
-		model_id = "gpt-3.5-turbo"
-		item = OPENAI_Models.Find(model_id)
+	item, _ := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+
+	body["model"] = model_id
+
+	// Prior messages of the conversation (conversation_id : id or "new")
+	conversation, messages, err := OpenAI_ConversationLoad(body, handler.AuthorizationData.IDX, model_id)
+	if err != nil {
+		HandleResultOpenAIError(ctx, err)
+		return
+	}
+	if conversation != nil {
+		ctx.Header(CONVERSATION_ID_HEADER, conversation.ID)
 	}
 
-	// ChatGPT-4 : 8192 tokens
-	if strings.Contains(model_id, "gpt-4") {
-		body["max_tokens"] = 4096
+	// System messages of the prompt template and the mandatory prefix (prompt_template, variables)
+	if err := OpenAI_PromptApply(body, handler.AuthorizationData.IDX, handler.AuthorizationData.Role, model_id); err != nil {
+		HandleResultOpenAIError(ctx, err)
+		return
 	}
 
-	body["model"] = model_id
-	utils.Logger.Log("[AI] Completions (Model:", item.ID, ", ID:", id, ")")
+	// Messages over the context window (openai_model_policies : context)
+	if count := OpenAI_TrimContext(body, model_id, handler.AuthorizationData.IDX); count > 0 {
+		utils.Logger.Log("[AI] Completions context (Model:", model_id, ", ID:", id, ") trimmed ", count, " messages")
+		ctx.Header(OPENAI_CONTEXT_HEADER, fmt.Sprintf("%d", count))
+	}
 
-	//
-	ctx.Header("Content-Type", "text/event-stream")
-	ctx.Header("Cache-Control", "no-cache")
-	ctx.Header("Connection", "keep-alive")
+	// Checking sampling parameters and prompt tokens
+	prompt_tokens, err := OpenAI_PrepareCompletions(body, model_id)
+	if err != nil {
+		HandleResultOpenAIError(ctx, err)
+		return
+	}
+
+	var chain = OpenAI_FallbackChain(model_id, handler.Plan, handler.AuthorizationData.APIKey)
+	utils.Logger.Log("[AI] Completions (Model:", item.ID, ", Fallbacks:", chain[1:], ", ID:", id, ", Stream:", stream,
+		", Tokens:", prompt_tokens, "/", body["max_tokens"], ")")
+
+	// Token and cost accounting
+	var usage = NewOpenAIUsage(handler.AuthorizationData.IDX, model_id, prompt_tokens)
+
+	// Cached response of the deterministic request (temperature 0)
+	var cache = NewOpenAICache(ctx, body, deterministic, handler.AuthorizationData.IDX)
+	if cache.Replay(ctx, usage) {
+		utils.Logger.Log("[AI] Completions (Model:", usage.Model, ", ID:", id, ") cache hit")
+		ok = true
+	} else if !stream {
+		ok = openai_completions_buffered(ctx, body, chain, usage, cache)
+	} else {
+		ok = openai_completions_stream(ctx, body, chain, usage, cache)
+	}
 
-	//context := ctx.Request.Context()
-	ctx_context, ctx_cancel := context.WithCancel(ctx.Request.Context())
+	// The request messages and the reply are saved
+	if ok && conversation != nil && !conversation.Append(messages, usage.Content()) {
+		utils.Logger.LogWarning("[AI] Conversation (", conversation.ID, ") IDX:", conversation.IDX, " saving failure.")
+	}
+}
 
+// Payload and upstream of the fallback model (index > 0)
+func openai_completions_attempt(body map[string]any, chain []string, index int) (map[string]any, *OpenAIUpstream) {
+	var model_id = chain[index]
+	var payload = body
+	if index > 0 {
+		var err *OpenAIError = nil
+		payload, err = openai_fallback_body(body, model_id)
+		if err != nil {
+			utils.Logger.LogWarning("[AI] Completions fallback (Model:", model_id, ") skipped: ", err.Message)
+			return nil, nil
+		}
+		utils.Logger.LogWarning("[AI] Completions fallback (Model:", model_id, ")")
+	}
+	return payload, OpenAI_Upstream(model_id)
+}
+
+// Non-streaming mode, return one chat.completion object
+func openai_completions_buffered(ctx *gin.Context, body map[string]any, chain []string, usage *OpenAIUsage, cache *OpenAICache) bool {
 	var data *httpx.HTTPData2 = nil
+	for i := range chain {
+		payload, upstream := openai_completions_attempt(body, chain, i)
+		if upstream == nil {
+			continue
+		}
+
+		usage.Model = chain[i]
+		ctx.Header(OPENAI_MODEL_HEADER, chain[i])
+		data = API_GPTCompletions2(upstream, payload, nil)
+		if data.ErrorCode == httpx.HTTP_RESULT_OK || !openai_fallback_retryable(data) {
+			break
+		}
+	}
+
+	if data == nil {
+		HandleResultFailed(ctx, -2, "Not found openai models")
+		return false
+	}
+	if data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return false
+	}
 
-	data = API_GPTCompletions2(body, func(index int, buffer *[]byte, length int, sender *httpx.HTTPData2) {
+	result, ok := data.Data().(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -2, "Response payload data error.")
+		return false
+	}
+
+	usage.ParseResult(result)
+	usage.Finish()
+	usage.Save()
+	cache.Save(usage.Model, result)
+
+	ctx.JSON(http.StatusOK, result)
+	return true
+}
 
-		if (sender.ErrorCode != httpx.HTTP_RESULT_OK) && (index > 0 || index == 0 && length > 0) {
-			return
+// Streaming mode, forward the SSE events
+// The fallback model is tried only before any SSE bytes have been written
+// The upstream stream is read by the httpx goroutine, the handler waits for its end (done),
+// the tokens are counted even if the client is gone
+func openai_completions_stream(ctx *gin.Context, body map[string]any, chain []string, usage *OpenAIUsage, cache *OpenAICache) bool {
+	var data *httpx.HTTPData2 = nil
+	var written = false
+	var received = false
+	// written, received, usage, cache and the writer are shared with the callback
+	var lock sync.Mutex
+
+	for i := range chain {
+		payload, upstream := openai_completions_attempt(body, chain, i)
+		if upstream == nil {
+			continue
 		}
 
-		if ctx.IsAborted() || index < 0 {
-			HandleResultFailed2(ctx, sender)
-			ctx_cancel()
-			return
+		var model_id = chain[i]
+		usage.Model = model_id
+		ctx.Header(OPENAI_MODEL_HEADER, model_id)
+
+		var done = make(chan struct{})
+		var done_once sync.Once
+		var finish = func() {
+			done_once.Do(func() { close(done) })
 		}
+		// The client is gone, the events are not written
+		var closed = false
 
-		if buffer != nil {
-			_, err := ctx.Writer.Write(*buffer)
-			if err != nil {
-				HandleResultFailed(ctx, -10, "Write stream failed.")
-				ctx_cancel()
+		data = API_GPTCompletions2(upstream, payload, func(index int, buffer *[]byte, length int, sender *httpx.HTTPData2) {
+			// Failed response or the end of the stream
+			if index < 0 || (index == 0 && length == 0) || buffer == nil {
+				lock.Lock()
+				if written && !closed {
+					ctx.Writer.Flush()
+				}
+				lock.Unlock()
+				finish()
 				return
 			}
-		}
 
-		// End
-		if index == 0 && length == 0 {
+			lock.Lock()
+			defer lock.Unlock()
+			if length <= 0 {
+				return
+			}
+			received = true
+			usage.ParseStream((*buffer)[0:length])
+			cache.Record((*buffer)[0:length])
+
+			if closed || ctx.IsAborted() || ctx.Request.Context().Err() != nil {
+				closed = true
+				return
+			}
+			if !written {
+				ctx.Header("Content-Type", "text/event-stream")
+				ctx.Header("Cache-Control", "no-cache")
+				ctx.Header("Connection", "keep-alive")
+				ctx.Status(http.StatusOK)
+				written = true
+			}
+			if _, err := ctx.Writer.Write((*buffer)[0:length]); err != nil {
+				closed = true
+				return
+			}
 			ctx.Writer.Flush()
-			ctx_cancel()
+		})
+
+		// The stream is read only when the request is succeeded,
+		// otherwise the request is finished (the callback may not be called)
+		if data.ErrorCode == httpx.HTTP_RESULT_OK {
+			<-done
+		} else {
+			finish()
 		}
-	})
-
-	//ctx.String(http.StatusOK, "")
-	select {
-	case <-ctx_context.Done():
-		if data.ErrorCode != httpx.HTTP_RESULT_OK {
-			HandleResultFailed2(ctx, data)
-			return
+
+		lock.Lock()
+		var started = received
+		lock.Unlock()
+		if started || data.ErrorCode == httpx.HTTP_RESULT_OK || !openai_fallback_retryable(data) {
+			break
 		}
-		return
 	}
 
+	if data == nil {
+		HandleResultFailed(ctx, -2, "Not found openai models")
+		return false
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if !received && data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return false
+	}
+
+	// The upstream tokens are consumed even if the client is gone
+	if received {
+		usage.Finish()
+		usage.Save()
+		cache.Save(usage.Model, nil)
+	}
+	return written
 }
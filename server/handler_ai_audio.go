@@ -0,0 +1,287 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Whisper : 25 MB
+	OPENAI_AUDIO_FILE_MAX = 25 << 20
+	// TTS : 4096 characters
+	OPENAI_SPEECH_INPUT_MAX = 4096
+)
+
+var openai_speech_voices = []string{"alloy", "echo", "fable", "onyx", "nova", "shimmer"}
+var openai_speech_formats = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/opus",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+var openai_transcription_formats = map[string]string{
+	"json":         "application/json",
+	"verbose_json": "application/json",
+	"text":         "text/plain;charset=utf-8",
+	"srt":          "text/plain;charset=utf-8",
+	"vtt":          "text/vtt;charset=utf-8",
+}
+
+// https://platform.openai.com/docs/api-reference/audio/createTranscription
+//
+//	curl https://api.openai.com/v1/audio/transcriptions \
+//	  -H "Authorization: Bearer $OPENAI_API_KEY" \
+//	  -H "Content-Type: multipart/form-data" \
+//	  -F file="@/path/to/file/audio.mp3" \
+//	  -F model="whisper-1" \
+//	  -F response_format="json"
+func HandleOpenAITranscriptions(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{DataType: "multipart", HasAuthorization: true, HasQuota: true, DefaultModel: "whisper-1", RequiredScopes: []string{SCOPE_AUDIO}})
+	if result < 0 {
+		return
+	}
+
+	file, err := handler.GetFile("file")
+	if err != nil {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "file",
+			"'file' is a required property"))
+		return
+	}
+	if file.Size > OPENAI_AUDIO_FILE_MAX {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusRequestEntityTooLarge, "invalid_request_error", "file",
+			fmt.Sprintf("Maximum content size limit (%d) exceeded (%d bytes read)", OPENAI_AUDIO_FILE_MAX, file.Size)))
+		return
+	}
+
+	// Checking models
+	var model_id = strings.ToLower(strings.TrimSpace(handler.GetValue("model", "whisper-1")))
+	item, upstream := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+
+	var format = strings.ToLower(strings.TrimSpace(handler.GetValue("response_format", "json")))
+	content_type, ok := openai_transcription_formats[format]
+	if !ok {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "response_format",
+			fmt.Sprintf("'%s' is not one of ['json', 'text', 'srt', 'verbose_json', 'vtt'] - 'response_format'", format)))
+		return
+	}
+
+	utils.Logger.Log("[AI] Transcriptions (Model:", item.ID, ", File:", file.Filename, ", Size:", file.Size, ", Format:", format, ")")
+
+	// Rebuild the multipart payload
+	var buffer bytes.Buffer
+	var writer = multipart.NewWriter(&buffer)
+	for k, values := range handler.Data.(*multipart.Form).Value {
+		if k == "model" || k == "response_format" {
+			continue
+		}
+		for _, v := range values {
+			writer.WriteField(k, v)
+		}
+	}
+	writer.WriteField("model", model_id)
+	writer.WriteField("response_format", format)
+
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err == nil {
+		var reader multipart.File
+		reader, err = file.Open()
+		if err == nil {
+			_, err = io.Copy(part, reader)
+			reader.Close()
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		HandleResultFailed(ctx, -1, "Request payload data error.")
+		return
+	}
+
+	//
+	var data = API_GPTTranscriptions2(upstream, model_id, buffer.Bytes(), writer.FormDataContentType())
+	if data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return
+	}
+
+	// The audio has no token usage, the transcribed text is counted
+	var text = ""
+	switch data.Content.(type) {
+	case string:
+		text = data.Content.(string)
+		ctx.Data(http.StatusOK, content_type, []byte(text))
+	case nil:
+		HandleResultFailed(ctx, -2, "Response payload data error.")
+		return
+	default:
+		if response, ok := data.Data().(map[string]any); ok {
+			text, _ = response["text"].(string)
+		}
+		ctx.JSON(http.StatusOK, data.Data())
+	}
+	OpenAI_UsageSave(handler.AuthorizationData.IDX, model_id, 0, OpenAI_CountText(text))
+}
+
+// https://platform.openai.com/docs/api-reference/audio/createSpeech
+//
+//	curl https://api.openai.com/v1/audio/speech \
+//	  -H "Authorization: Bearer $OPENAI_API_KEY" \
+//	  -H "Content-Type: application/json" \
+//	  -d '{
+//	    "model": "tts-1",
+//	    "input": "The quick brown fox jumped over the lazy dog.",
+//	    // alloy, echo, fable, onyx, nova, and shimmer
+//	    "voice": "alloy",
+//	    // mp3 (default), opus, aac, flac, wav, pcm
+//	    "response_format": "mp3",
+//	    // 0.25 to 4.0
+//	    "speed": 1.0
+//	  }'
+func HandleOpenAISpeech(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{DataType: "json", HasAuthorization: true, HasQuota: true, DefaultModel: "tts-1", RequiredScopes: []string{SCOPE_AUDIO}})
+	if result < 0 {
+		return
+	}
+
+	body, ok := handler.Data.(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -1, "Request payload data error.")
+		return
+	}
+
+	// Checking models
+	model_id, ok := body["model"].(string)
+	if !ok {
+		model_id = "tts-1"
+	}
+	model_id = strings.ToLower(strings.TrimSpace(model_id))
+	item, upstream := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+	body["model"] = model_id
+
+	input, ok := body["input"].(string)
+	if !ok || len(strings.TrimSpace(input)) == 0 {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+			"'input' is a required property"))
+		return
+	}
+	if len([]rune(input)) > OPENAI_SPEECH_INPUT_MAX {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+			fmt.Sprintf("'input' is too long, maximum %d characters", OPENAI_SPEECH_INPUT_MAX)))
+		return
+	}
+
+	voice, _ := body["voice"].(string)
+	if !slices.Contains(openai_speech_voices, voice) {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "voice",
+			fmt.Sprintf("'%s' is not one of %v - 'voice'", voice, openai_speech_voices)))
+		return
+	}
+
+	format, ok := body["response_format"].(string)
+	if !ok {
+		format = "mp3"
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	content_type, ok := openai_speech_formats[format]
+	if !ok {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "response_format",
+			fmt.Sprintf("'%s' is not one of ['mp3', 'opus', 'aac', 'flac', 'wav', 'pcm'] - 'response_format'", format)))
+		return
+	}
+	body["response_format"] = format
+
+	if value, ok := body["speed"]; ok && value != nil {
+		speed, ok := to_number(value)
+		if !ok || speed < 0.25 || speed > 4.0 {
+			HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "speed",
+				fmt.Sprintf("'%v' is out of range [0.25, 4.0] - 'speed'", value)))
+			return
+		}
+	}
+
+	utils.Logger.Log("[AI] Speech (Model:", item.ID, ", Voice:", voice, ", Format:", format, ", Length:", len(input), ")")
+
+	//
+	var written = false
+	// The client is gone, the audio is not written
+	var closed = false
+	// written, closed and the writer are shared with the callback
+	var lock sync.Mutex
+	var done = make(chan struct{})
+	var done_once sync.Once
+	var finish = func() {
+		done_once.Do(func() { close(done) })
+	}
+
+	var data = API_GPTSpeech2(upstream, body, func(index int, buffer *[]byte, length int, sender *httpx.HTTPData2) {
+		// Failed response or the end of the stream
+		if index < 0 || (index == 0 && length == 0) || buffer == nil {
+			lock.Lock()
+			if written && !closed {
+				ctx.Writer.Flush()
+			}
+			lock.Unlock()
+			finish()
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if length <= 0 || closed {
+			return
+		}
+		if ctx.IsAborted() || ctx.Request.Context().Err() != nil {
+			closed = true
+			return
+		}
+		if !written {
+			ctx.Header("Content-Type", content_type)
+			ctx.Header("Cache-Control", "no-cache")
+			ctx.Status(http.StatusOK)
+			written = true
+		}
+		if _, err := ctx.Writer.Write((*buffer)[0:length]); err != nil {
+			closed = true
+			return
+		}
+		ctx.Writer.Flush()
+	})
+
+	// The stream is read only when the request is succeeded,
+	// otherwise the request is finished (the callback may not be called)
+	if data.ErrorCode == httpx.HTTP_RESULT_OK {
+		<-done
+	} else {
+		finish()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if !written && data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return
+	}
+	// The speech has no token usage, the input is counted
+	if written {
+		OpenAI_UsageSave(handler.AuthorizationData.IDX, model_id, OpenAI_CountText(input), 0)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const OPENAI_EMBEDDINGS_INPUT_MAX = 2048
+
+// https://platform.openai.com/docs/api-reference/embeddings
+// curl https://api.openai.com/v1/embeddings \
+//   -H "Content-Type: application/json" \
+//   -H "Authorization: Bearer $OPENAI_API_KEY" \
+//   -d '{
+//     "model": "text-embedding-ada-002",
+//     // string, array of strings, array of tokens or array of token arrays
+//     "input": ["The food was delicious", "and the waiter..."],
+//     // float (default) or base64
+//     "encoding_format": "float",
+//     "user": id
+//   }'
+
+// Count of input items, string : 1, array : len
+func embeddings_input_count(input any) (int, *OpenAIError) {
+	switch input.(type) {
+	case string:
+		if len(strings.TrimSpace(input.(string))) == 0 {
+			break
+		}
+		return 1, nil
+	case []any:
+		var list = input.([]any)
+		if len(list) == 0 {
+			break
+		}
+		if len(list) > OPENAI_EMBEDDINGS_INPUT_MAX {
+			return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+				fmt.Sprintf("'$.input' is invalid, maximum %d items per request", OPENAI_EMBEDDINGS_INPUT_MAX))
+		}
+
+		// Array of tokens : one input
+		if _, ok := to_number(list[0]); ok {
+			for _, v := range list {
+				if _, ok := to_number(v); !ok {
+					return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+						"'$.input' is invalid, mixed tokens and strings")
+				}
+			}
+			return 1, nil
+		}
+
+		// Array of strings or array of token arrays
+		for _, v := range list {
+			switch v.(type) {
+			case string:
+				if len(v.(string)) == 0 {
+					return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+						"'$.input' is invalid, empty string")
+				}
+			case []any:
+				if len(v.([]any)) == 0 {
+					return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+						"'$.input' is invalid, empty tokens")
+				}
+			default:
+				return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+					fmt.Sprintf("'%v' is not valid under any of the given schemas - 'input'", v))
+			}
+		}
+		return len(list), nil
+	}
+
+	return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+		"'$.input' is invalid. Please check the API reference: https://platform.openai.com/docs/api-reference.")
+}
+
+// Tokens of the input when the upstream has no usage, the token arrays are counted as is
+func embeddings_input_tokens(input any) int {
+	switch input.(type) {
+	case string:
+		return OpenAI_CountText(input.(string))
+	case []any:
+		var list = input.([]any)
+		if len(list) > 0 {
+			if _, ok := to_number(list[0]); ok {
+				return len(list)
+			}
+		}
+		var tokens = 0
+		for _, v := range list {
+			tokens += embeddings_input_tokens(v)
+		}
+		return tokens
+	}
+	return 0
+}
+
+func HandleOpenAIEmbeddings(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{DataType: "json", HasAuthorization: true, HasQuota: true, RequiredScopes: []string{SCOPE_EMBEDDINGS}})
+	if result < 0 {
+		return
+	}
+
+	body, ok := handler.Data.(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -1, "Request payload data error.")
+		return
+	}
+
+	id, ok := body["user"].(string)
+	if !ok {
+		id = "id0000"
+	}
+	id = strings.ToLower(strings.TrimSpace(id))
+
+	// Checking models
+	model_id, ok := body["model"].(string)
+	if !ok {
+		model_id = ""
+	}
+	model_id = strings.ToLower(strings.TrimSpace(model_id))
+	item, upstream := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+	body["model"] = model_id
+
+	// Checking input
+	count, err := embeddings_input_count(body["input"])
+	if err != nil {
+		HandleResultOpenAIError(ctx, err)
+		return
+	}
+
+	// Checking encoding format
+	format, ok := body["encoding_format"].(string)
+	if !ok {
+		format = "float"
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "float" && format != "base64" {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "encoding_format",
+			fmt.Sprintf("'%s' is not one of ['float', 'base64'] - 'encoding_format'", format)))
+		return
+	}
+	body["encoding_format"] = format
+
+	utils.Logger.Log("[AI] Embeddings (Model:", item.ID, ", ID:", id, ", Inputs:", count, ", Format:", format, ")")
+
+	//
+	var data = API_GPTEmbeddings2(upstream, body)
+	if data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return
+	}
+
+	response, ok := data.Data().(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -2, "Response payload data error.")
+		return
+	}
+
+	var tokens = 0
+	if usage, ok := response["usage"].(map[string]any); ok {
+		if number, ok := to_number(usage["prompt_tokens"]); ok {
+			tokens = int(number)
+		}
+	} else {
+		tokens = embeddings_input_tokens(body["input"])
+	}
+	OpenAI_UsageSave(handler.AuthorizationData.IDX, model_id, tokens, 0)
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	ASSETS_PATH        = "assets"
+	ASSETS_URL_PATH    = "/api/assets"
+	ASSETS_IMAGES_PATH = "images"
+)
+
+// https://platform.openai.com/docs/api-reference/images/create
+// curl https://api.openai.com/v1/images/generations \
+//   -H "Content-Type: application/json" \
+//   -H "Authorization: Bearer $OPENAI_API_KEY" \
+//   -d '{
+//     "model": "dall-e-3",
+//     "prompt": "a white siamese cat",
+//     "n": 1,
+//     "size": "1024x1024",
+//     // url (default) or b64_json
+//     "response_format": "url"
+//   }'
+// Response:
+//	{
+//		"created": 1589478378,
+//		"data": [
+//			{ "revised_prompt": "...", "url": "https://..." }
+//		]
+//	}
+
+// Public url of /api/assets, the request headers (Host, X-Forwarded-Proto) are not trusted,
+// default: the server address and port (https_port when it is set)
+func assets_base_url() string {
+	if len(server_config.AssetsUrl) > 0 {
+		return strings.TrimRight(server_config.AssetsUrl, "/") + ASSETS_URL_PATH
+	}
+
+	var host = server_config.Address
+	if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	var scheme = "http"
+	var port = server_config.Port
+	if server_config.HTTPSPort > 0 {
+		scheme = "https"
+		port = server_config.HTTPSPort
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)), ASSETS_URL_PATH)
+}
+
+func image_extension(buffer []byte) string {
+	switch http.DetectContentType(buffer) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	}
+	return ".png"
+}
+
+// Save image to assets/images/yyyymmdd/, return the relative path
+func image_save(buffer []byte) (string, error) {
+	if len(buffer) == 0 {
+		return "", errors.New("image data is empty")
+	}
+
+	var name = strings.ToLower(utils.SHA256(string(buffer)))[0:32] + image_extension(buffer)
+	var filename = path.Join(ASSETS_IMAGES_PATH, time.Now().Format("20060102"), name)
+	if !utils.WriteFile(path.Join(ASSETS_PATH, filename), buffer) {
+		return "", errors.New("save image failed")
+	}
+	return filename, nil
+}
+
+// Upstream urls are expired after an hour
+func image_download(url string) ([]byte, error) {
+	var client = http.Client{
+		Timeout: 60 * time.Second,
+	}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("download image failed: " + response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+func HandleOpenAIImages(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{DataType: "json", HasAuthorization: true, HasQuota: true, DefaultModel: "dall-e-2", RequiredScopes: []string{SCOPE_IMAGES}})
+	if result < 0 {
+		return
+	}
+
+	body, ok := handler.Data.(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -1, "Request payload data error.")
+		return
+	}
+
+	id, ok := body["user"].(string)
+	if !ok {
+		id = "id0000"
+	}
+	id = strings.ToLower(strings.TrimSpace(id))
+
+	// Checking models
+	model_id, ok := body["model"].(string)
+	if !ok {
+		model_id = "dall-e-2"
+	}
+	model_id = strings.ToLower(strings.TrimSpace(model_id))
+	item, upstream := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+	body["model"] = model_id
+
+	prompt, ok := body["prompt"].(string)
+	if !ok || len(strings.TrimSpace(prompt)) == 0 {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "prompt",
+			"'prompt' is a required property"))
+		return
+	}
+
+	format, ok := body["response_format"].(string)
+	if !ok {
+		format = "url"
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "url" && format != "b64_json" {
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "response_format",
+			fmt.Sprintf("'%s' is not one of ['url', 'b64_json'] - 'response_format'", format)))
+		return
+	}
+
+	// The images are stored locally, so take the data without a second download
+	body["response_format"] = "b64_json"
+
+	utils.Logger.Log("[AI] Images (Model:", item.ID, ", ID:", id, ", Format:", format, ")")
+
+	//
+	var data = API_GPTImages2(upstream, body)
+	if data.ErrorCode != httpx.HTTP_RESULT_OK {
+		HandleResultFailed2(ctx, data)
+		return
+	}
+
+	response, ok := data.Data().(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -2, "Response payload data error.")
+		return
+	}
+
+	list, ok := response["data"].([]any)
+	if !ok {
+		HandleResultFailed(ctx, -2, "Response payload data error.")
+		return
+	}
+
+	var base_url = assets_base_url()
+	for i, v := range list {
+		image, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		text, ok := image["b64_json"].(string)
+		if ok && format == "b64_json" {
+			continue
+		}
+
+		var buffer []byte = nil
+		var err error = nil
+		if ok {
+			buffer, err = base64.StdEncoding.DecodeString(text)
+		} else if url, ok := image["url"].(string); ok {
+			buffer, err = image_download(url)
+		} else {
+			err = errors.New("image data not found")
+		}
+		if err != nil {
+			utils.Logger.LogError("[AI] Images (Index:", i, ") Error: ", err)
+			HandleResultFailed(ctx, -3, "Response image data error.")
+			return
+		}
+
+		if format == "b64_json" {
+			delete(image, "url")
+			image["b64_json"] = base64.StdEncoding.EncodeToString(buffer)
+			continue
+		}
+
+		// Permanent links on our own server
+		filename, err := image_save(buffer)
+		if err != nil {
+			utils.Logger.LogError("[AI] Images (Index:", i, ") Error: ", err)
+			HandleResultFailed(ctx, -4, "Save image data failed.")
+			return
+		}
+		delete(image, "b64_json")
+		image["url"] = base_url + "/" + filename
+	}
+	response["data"] = list
+
+	// The images have no token usage, the prompt is counted
+	OpenAI_UsageSave(handler.AuthorizationData.IDX, model_id, OpenAI_CountText(prompt), 0)
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mcmcx.com/gpt-server/tokenizer"
+)
+
+//	curl https://127.0.0.1:9443/server/v1/tokenize \
+//	  -H "Content-Type: application/json" \
+//	  -H "Authorization: <idx>-<token>" \
+//	  -d '{
+//	    "model": "gpt-3.5-turbo",
+//	    // chat messages (prompt tokens)
+//	    "messages": [{"role": "user", "content": "Hello!"}],
+//	    // or text : string or array of strings
+//	    "input": ["Hello!"]
+//	  }'
+func HandleOpenAITokenize(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{DataType: "json", HasAuthorization: true, RequiredScopes: []string{SCOPE_CHAT}})
+	if result < 0 {
+		return
+	}
+
+	body, ok := handler.Data.(map[string]any)
+	if !ok {
+		HandleResultFailed(ctx, -1, "Request payload data error.")
+		return
+	}
+
+	model_id, ok := body["model"].(string)
+	if !ok {
+		model_id = "gpt-3.5-turbo"
+	}
+	model_id = strings.ToLower(strings.TrimSpace(model_id))
+	item, _ := openai_model_check(ctx, model_id)
+	if item == nil {
+		return
+	}
+
+	var encoding = tokenizer.CL100K_BASE
+	if !OpenAI_TokenizerLoaded() {
+		encoding = "estimate"
+	}
+	var context_tokens = OpenAI_Policy(model_id).ContextTokens
+
+	// Chat messages
+	if messages, ok := body["messages"].([]any); ok {
+		var prompt_tokens = OpenAI_CountMessages(messages)
+		ctx.JSON(http.StatusOK, gin.H{
+			"object":           "tokenize",
+			"model":            model_id,
+			"encoding":         encoding,
+			"prompt_tokens":    prompt_tokens,
+			"context_tokens":   context_tokens,
+			"remaining_tokens": context_tokens - prompt_tokens,
+		})
+		return
+	}
+
+	// Text
+	var input = []string{}
+	switch body["input"].(type) {
+	case string:
+		input = append(input, body["input"].(string))
+	case []any:
+		for _, v := range body["input"].([]any) {
+			text, ok := v.(string)
+			if !ok {
+				HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+					"'$.input' is invalid, array of strings"))
+				return
+			}
+			input = append(input, text)
+		}
+	default:
+		HandleResultOpenAIError(ctx, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "input",
+			"'messages' or 'input' is a required property"))
+		return
+	}
+
+	var total_tokens = 0
+	var list = []gin.H{}
+	for i, text := range input {
+		// The text is encoded once, the tokens are estimated without the vocabulary
+		var ids = OpenAI_EncodeText(text)
+		var count = len(ids)
+		if !OpenAI_TokenizerLoaded() {
+			count = OpenAI_CountText(text)
+		}
+		total_tokens += count
+		list = append(list, gin.H{
+			"object": "tokens",
+			"index":  i,
+			"tokens": count,
+			"ids":    ids,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object":         "list",
+		"model":          model_id,
+		"encoding":       encoding,
+		"data":           list,
+		"total_tokens":   total_tokens,
+		"context_tokens": context_tokens,
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+	"mcmcx.com/gpt-server/utils"
+)
+
+type TAPIKeyData struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Models []string `json:"models"`
+	// Seconds, 0 : never expires
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// Keys of the user (GET), new key (POST : name, scopes, models, expires_in)
+func HandleUserAPIKeys(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var list = db_apikey_list(idx)
+
+	if handler.Method != http.MethodPost {
+		sort.Slice(list, func(i, j int) bool { return list[i].CreateTime > list[j].CreateTime })
+		ctx.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   list,
+		})
+		return
+	}
+
+	var key_data TAPIKeyData = TAPIKeyData{}
+	if err := handler.GetData(&key_data); err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return
+	}
+	if len(list) >= API_KEY_MAX {
+		HandleResultFailed(ctx, -101, "too many api keys")
+		return
+	}
+
+	var data = &DBAPIKeyData{
+		IDX:    idx,
+		Name:   strings.TrimSpace(key_data.Name),
+		Scopes: []string{},
+		Models: []string{},
+	}
+	for _, v := range key_data.Scopes {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if !slices.Contains(API_KEY_SCOPES, v) {
+			HandleResultFailed(ctx, -102, "scope ("+v+") invalidate")
+			return
+		}
+		data.Scopes = append(data.Scopes, v)
+	}
+	for _, v := range key_data.Models {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if len(v) > 0 {
+			data.Models = append(data.Models, v)
+		}
+	}
+
+	key, ok := db_apikey_add(data, key_data.ExpiresIn)
+	if !ok {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[APIKey] IDX:", idx, " New (", data.ID, ", ", data.Name, ") Scopes:", data.Scopes,
+		" Models:", data.Models, " IPAddress (", handler.RemoteAddress, ")")
+
+	// The key is returned only once
+	ctx.JSON(http.StatusOK, gin.H{
+		"key":  key,
+		"data": data,
+	})
+}
+
+// Revoke the key
+func HandleUserAPIKeyDelete(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var id = strings.TrimSpace(ctx.Param("id"))
+	if !db_apikey_delete(idx, id) {
+		HandleResultFailed(ctx, -101, "api key not found")
+		return
+	}
+
+	utils.Logger.LogWarning("[APIKey] IDX:", idx, " Revoke (", id, ") IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"deleted": true,
+	})
+}
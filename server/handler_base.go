@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"golang.org/x/exp/maps"
 	database_redis "mcmcx.com/gpt-server/database/redis"
 	"mcmcx.com/gpt-server/utils"
@@ -23,6 +25,15 @@ import (
 type HandlerOptions struct {
 	//
 	HasAuthorization bool
+	// Plan quota of the user (HasAuthorization), model of the request or the default model
+	HasQuota     bool
+	DefaultModel string
+	// Scopes of the API key (session tokens have all scopes)
+	RequiredScopes []string
+	// The token is not verified by the two-factor authentication (/server/auth, /server/logout)
+	AllowPending bool
+	// Roles of the user (the administrators have all roles)
+	RequiredRoles []string
 
 	//
 	DataType string
@@ -52,6 +63,10 @@ type Handler struct {
 
 	//
 	AuthorizationData *TAuthorizationData
+	// Plan of the user (HasQuota), nil : unlimited
+	Plan *UserPlan
+	// OpenAI compatible error of the authorization, scopes and quota (HasQuota)
+	APIError *OpenAIError
 }
 
 // API: Authorization
@@ -64,6 +79,12 @@ type TAuthorizationData struct {
 	IPAddress   string
 	IPLocalized string
 	DeviceUID   string
+	// Authorization: Bearer gs-...
+	APIKey *DBAPIKeyData
+	// Two-factor authentication not verified
+	Pending bool
+	// Role of the user (token or account)
+	Role string
 }
 
 type DBAuthorizationData struct {
@@ -78,6 +99,9 @@ type DBAuthorizationData struct {
 	IPAddress   string `json:"ip_address"`
 	IPLocalized string `json:"ip_localized"`
 	DeviceUID   string `json:"device_uid"`
+	// Two-factor authentication not verified
+	Pending bool   `json:"pending"`
+	Role    string `json:"role"`
 }
 
 func (I *Handler) TimeStamp() uint32 {
@@ -116,10 +140,40 @@ func (I *Handler) GetData(data any) error {
 		}
 		return json.Unmarshal(bytes, data)
 	}
+	// form tags
+	if I.DataType == "multipart" {
+		return I.Context.ShouldBindWith(data, binding.FormMultipart)
+	}
 
 	return errors.New("Data not support format")
 }
 
+func (I *Handler) GetFile(name string) (*multipart.FileHeader, error) {
+	form, ok := I.Data.(*multipart.Form)
+	if I.DataType != "multipart" || !ok {
+		return nil, errors.New("Data not support format")
+	}
+
+	files, ok := form.File[name]
+	if !ok || len(files) == 0 {
+		return nil, errors.New("not found file (" + name + ")")
+	}
+	return files[0], nil
+}
+
+func (I *Handler) GetValue(name string, def string) string {
+	form, ok := I.Data.(*multipart.Form)
+	if I.DataType != "multipart" || !ok {
+		return def
+	}
+
+	values, ok := form.Value[name]
+	if !ok || len(values) == 0 {
+		return def
+	}
+	return values[0]
+}
+
 func (I *Handler) InitData() error {
 
 	//
@@ -140,13 +194,26 @@ func (I *Handler) InitData() error {
 	//
 	defer I.Context.Request.Body.Close()
 
-	//POST method read all payload
-	if I.Context.Request.Method != http.MethodPost {
+	//POST method read all payload, DELETE : the payload is optional (account deletion)
+	if I.Context.Request.Method != http.MethodPost && I.Context.Request.Method != http.MethodDelete {
 		return nil
 	}
 
 	I.ContentLength = int(I.Context.Request.ContentLength)
 
+	// multipart/form-data, files over router.MaxMultipartMemory are stored in temporary files
+	var content_type = strings.ToLower(I.GetHeader("Content-Type", ""))
+	if strings.HasPrefix(content_type, "multipart/form-data") {
+		form, err := I.Context.MultipartForm()
+		if err != nil {
+			return err
+		}
+		I.Length = I.ContentLength
+		I.Data = form
+		I.DataType = "multipart"
+		return nil
+	}
+
 	//var err error = nil
 	var length = 0
 	//var buffer []byte = make([]byte, 0)
@@ -271,6 +338,24 @@ func (I *Handler) Init(ctx *gin.Context, options *HandlerOptions) int {
 		_, I.Error = I.Authorization(authorization_text, &authorization_data)
 		if I.Error == nil {
 			I.AuthorizationData = &authorization_data
+		} else if options.HasQuota {
+			I.APIError = NewOpenAIError(http.StatusUnauthorized, "invalid_request_error", "", "Incorrect API key provided.")
+			I.APIError.Code = "invalid_api_key"
+		}
+	}
+
+	if I.Error == nil && I.AuthorizationData != nil && I.AuthorizationData.Pending && !options.AllowPending {
+		I.Error = Error2FARequired
+		if options.HasQuota {
+			I.APIError = NewOpenAIError(http.StatusUnauthorized, "invalid_request_error", "", "Two-factor authentication required.")
+			I.APIError.Code = "mfa_required"
+		}
+	}
+
+	if I.Error == nil && I.AuthorizationData != nil {
+		I.APIError = I.check_access(options)
+		if I.APIError != nil {
+			I.Error = errors.New(I.APIError.Message)
 		}
 	}
 
@@ -310,6 +395,75 @@ func (I *Handler) ResultError(code int, message string) int {
 	return 0
 }
 
+// Roles of the user, scopes of the role and the API key, models of the API key, plan quota of the user
+func (I *Handler) check_access(options *HandlerOptions) *OpenAIError {
+	var role = I.AuthorizationData.Role
+	if len(options.RequiredRoles) > 0 && !user_role_check(role, options.RequiredRoles) {
+		var err = NewOpenAIError(http.StatusForbidden, "invalid_request_error", "",
+			fmt.Sprintf("The role (%s) is not allowed.", role))
+		err.Code = "permission_denied"
+		return err
+	}
+	for _, v := range options.RequiredScopes {
+		if !user_role_has_scope(role, v) {
+			var err = NewOpenAIError(http.StatusForbidden, "invalid_request_error", "",
+				fmt.Sprintf("The role (%s) does not have the required scope (%s).", role, v))
+			err.Code = "insufficient_scope"
+			return err
+		}
+	}
+
+	var api_key = I.AuthorizationData.APIKey
+	if api_key != nil {
+		for _, v := range options.RequiredScopes {
+			if !api_key.HasScope(v) {
+				var err = NewOpenAIError(http.StatusForbidden, "invalid_request_error", "",
+					fmt.Sprintf("The API key does not have the required scope (%s).", v))
+				err.Code = "insufficient_scope"
+				return err
+			}
+		}
+	}
+
+	if !options.HasQuota {
+		return nil
+	}
+
+	var model_id = I.model_id(options.DefaultModel)
+	if api_key != nil && len(model_id) > 0 && !api_key.AllowModel(model_id) {
+		var err = NewOpenAIError(http.StatusForbidden, "invalid_request_error", "model",
+			fmt.Sprintf("The model '%s' is not allowed for the API key.", model_id))
+		err.Code = "model_not_allowed"
+		return err
+	}
+
+	I.Plan = UserPlan_Get(I.AuthorizationData.IDX)
+	if I.Plan != nil {
+		return I.Plan.Check(I.AuthorizationData.IDX, model_id)
+	}
+	return nil
+}
+
+// Model of the request (json or multipart)
+func (I *Handler) model_id(def string) string {
+	var model_id = def
+	if body, ok := I.Data.(map[string]any); ok {
+		if value, ok := body["model"].(string); ok {
+			model_id = value
+		}
+	} else {
+		model_id = I.GetValue("model", def)
+	}
+	return strings.ToLower(strings.TrimSpace(model_id))
+}
+
+func (I *Handler) IsAdmin() bool {
+	if I.AuthorizationData == nil {
+		return false
+	}
+	return I.AuthorizationData.Role == ROLE_ADMIN
+}
+
 func (I *Handler) PrintHeaders() {
 	utils.Logger.Log("Request Headers :")
 	for k, v := range I.Headers {
@@ -322,9 +476,16 @@ func (I *Handler) PrintUserAgent() {
 }
 
 func (I *Handler) Authorization(text string, data *TAuthorizationData) (int, error) {
+	text = authorization_text(text)
 	if len(text) == 0 {
 		return -10, errors.New("not authorization data")
 	}
+	if IsAPIKey(text) {
+		return I.authorization_apikey(text, data)
+	}
+	if IsJWT(text) {
+		return I.authorization_jwt(text, data)
+	}
 
 	var values []string = strings.Split(text, "-")
 	if len(values) == 0 {
@@ -355,6 +516,11 @@ func (I *Handler) Authorization(text string, data *TAuthorizationData) (int, err
 
 	//
 	data.DeviceUID = db_data.DeviceUID
+	data.Pending = db_data.Pending
+	data.Role = db_data.Role
+	if len(data.Role) == 0 {
+		data.Role = user_role(data.IDX)
+	}
 
 	//
 	if result >= 1 {
@@ -365,6 +531,69 @@ func (I *Handler) Authorization(text string, data *TAuthorizationData) (int, err
 	return 0, nil
 }
 
+// "Bearer <token>" or "<idx>-<token>"
+func authorization_text(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) > 7 && strings.EqualFold(text[0:7], "Bearer ") {
+		text = strings.TrimSpace(text[7:])
+	}
+	return text
+}
+
+// API key (gs-...), the keys of the disabled accounts are refused
+func (I *Handler) authorization_apikey(key string, data *TAuthorizationData) (int, error) {
+	var api_key = db_apikey_get(key)
+	if api_key == nil {
+		return -12, errors.New("api key invalidate or expiration")
+	}
+	var user = db_user_get(api_key.IDX)
+	if user == nil || user.Status == USER_STATUS_DISABLED {
+		return -12, errors.New("api key invalidate or expiration")
+	}
+
+	data.IDX = api_key.IDX
+	data.AuthCode = ""
+	data.AuthToken = ""
+	data.AuthTime = utils.DateFormat(time.Now(), 3)
+	data.IPAddress = I.RemoteAddress
+	data.IPLocalized = IPLocalized(I.RemoteAddress).Localize()
+	data.DeviceUID = "apikey:" + api_key.ID
+	data.APIKey = api_key
+	data.Role = user.Role
+
+	db_apikey_used(key, api_key)
+	return 0, nil
+}
+
+// Signed token (jwt), verified without the authorization data
+// The auth code is not in the token (db_login_code)
+func (I *Handler) authorization_jwt(text string, data *TAuthorizationData) (int, error) {
+	if !JWT_Enabled() {
+		return -11, errors.New("authorization data invalidate")
+	}
+	claims, err := JWT_Verify(text)
+	if err != nil {
+		return -12, errors.New("authorization data expiration or expiration")
+	}
+	if !utils.CheckAccountIDX(claims.IDX, 6, 12) {
+		return -11, errors.New("authorization data invalidate")
+	}
+
+	data.IDX = claims.IDX
+	data.AuthCode = ""
+	data.AuthToken = claims.ID
+	data.AuthTime = utils.DateFormat(time.Now(), 3)
+	data.IPAddress = I.RemoteAddress
+	data.IPLocalized = IPLocalized(I.RemoteAddress).Localize()
+	data.DeviceUID = claims.DeviceUID
+	data.Pending = claims.Pending
+	data.Role = claims.Role
+	if len(data.Role) == 0 {
+		data.Role = user_role(data.IDX)
+	}
+	return 0, nil
+}
+
 func db_auth_data_verfiy(data *TAuthorizationData) (int, *DBAuthorizationData) {
 	var db_id = fmt.Sprintf("auth_user_%d_%s", data.IDX, data.AuthToken)
 	var db_auth_data DBAuthorizationData
@@ -385,7 +614,8 @@ func db_auth_data_verfiy(data *TAuthorizationData) (int, *DBAuthorizationData) {
 
 	db_auth_data.AuthCount++
 	db_auth_data.AuthTime = utils.DateFormat(time.Now(), 3)
-	if !database_redis.PushJson[DBAuthorizationData](db_id, &db_auth_data, database_redis.KEEP_TIME, false) {
+	// The expire time of the token is not changed
+	if !database_redis.UpdateJson[DBAuthorizationData](db_id, &db_auth_data, false) {
 		return -2, nil
 	}
 	return result, &db_auth_data
@@ -394,6 +624,13 @@ func db_auth_data_verfiy(data *TAuthorizationData) (int, *DBAuthorizationData) {
 func InitHandler(ctx *gin.Context, options *HandlerOptions) (int, *Handler) {
 	handler := &Handler{}
 	result := handler.Init(ctx, options)
+	if result < 0 && handler.APIError != nil {
+		if handler.AuthorizationData != nil {
+			utils.Logger.LogWarning("[Auth] IDX:", handler.AuthorizationData.IDX, " ", handler.APIError.Message)
+		}
+		HandleResultOpenAIError(ctx, handler.APIError)
+		return result, handler
+	}
 	if result < 0 {
 		handler.ResultError(-1, "Init handler error: "+handler.Error.Error())
 		return result, handler
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mcmcx.com/gpt-server/utils"
+)
+
+type TConversationData struct {
+	Title string `form:"title" json:"title"`
+}
+
+func conversation_data(data *DBConversation, messages bool) gin.H {
+	var result = gin.H{
+		"id":          data.ID,
+		"title":       data.Title,
+		"model":       data.Model,
+		"messages":    len(data.Messages),
+		"create_time": data.CreateTime,
+		"update_time": data.UpdateTime,
+	}
+	if messages {
+		result["messages"] = data.Messages
+	}
+	return result
+}
+
+// Conversations of the user (the latest first)
+func HandleUserConversations(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_CHAT}})
+	if result < 0 {
+		return
+	}
+
+	var list = db_conversation_list(handler.AuthorizationData.IDX)
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdateTime > list[j].UpdateTime })
+
+	var data = []gin.H{}
+	for _, v := range list {
+		data = append(data, conversation_data(v, false))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// Conversation with the messages (GET), rename (POST : title), delete (DELETE)
+func HandleUserConversation(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_CHAT}})
+	if result < 0 {
+		return
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var id = strings.ToLower(strings.TrimSpace(ctx.Param("id")))
+	// Serialized with the saving of the chat requests
+	if (handler.Method == http.MethodPost || handler.Method == http.MethodDelete) && utils.CheckToken(id) {
+		if !db_conversation_lock(idx, id) {
+			HandleResultFailed(ctx, -103, "conversation is busy")
+			return
+		}
+		defer db_conversation_unlock(idx, id)
+	}
+	var conversation = db_conversation_get(idx, id)
+	if conversation == nil {
+		HandleResultFailed(ctx, -101, "conversation not found")
+		return
+	}
+
+	switch handler.Method {
+	case http.MethodDelete:
+		if !db_conversation_delete(idx, conversation.ID) {
+			HandleResultFailed(ctx, -104, "conversation not found")
+			return
+		}
+		utils.Logger.Log("[AI] Conversation (", conversation.ID, ") IDX:", idx, " deleted")
+		ctx.JSON(http.StatusOK, gin.H{
+			"id":      conversation.ID,
+			"deleted": true,
+		})
+	case http.MethodPost:
+		var conversation_title TConversationData = TConversationData{}
+		if err := handler.GetData(&conversation_title); err != nil {
+			HandleResultFailed(ctx, -100, err.Error())
+			return
+		}
+		var title = strings.Join(strings.Fields(conversation_title.Title), " ")
+		if len(title) == 0 || len([]rune(title)) > CONVERSATION_TITLE_MAX {
+			HandleResultFailed(ctx, -102, "title invalidate")
+			return
+		}
+		conversation.Title = title
+		if !db_conversation_save(conversation) {
+			HandleResultFailed(ctx, -104, "conversation saving failure")
+			return
+		}
+		ctx.JSON(http.StatusOK, conversation_data(conversation, false))
+	default:
+		ctx.JSON(http.StatusOK, conversation_data(conversation, true))
+	}
+}
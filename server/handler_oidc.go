@@ -0,0 +1,249 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+// Account of the claim value (oidc_user_<sha256 of issuer and value>)
+func db_oidc_user_id(value string) string {
+	var issuer = strings.TrimRight(server_config.OIDC.Issuer, "/")
+	return fmt.Sprintf("oidc_user_%s", strings.ToLower(utils.SHA256(issuer + "|" + value))[0:32])
+}
+
+var oidc_name_regex = regexp.MustCompile("[^0-9a-zA-Z_.-]+")
+
+// Claim value of the account mapping, the emails are verified and in the allowed domains
+func oidc_claim_value(claims *OIDCClaims) (string, error) {
+	var email = strings.ToLower(strings.TrimSpace(claims.Email))
+	if len(server_config.OIDC.AllowDomains) > 0 {
+		var index = strings.LastIndex(email, "@")
+		if index < 0 || !claims.IsEmailVerified() ||
+			!slices.Contains(server_config.OIDC.AllowDomains, strings.ToLower(email[index+1:])) {
+			return "", ErrorOIDCAccount
+		}
+	}
+
+	if strings.EqualFold(server_config.OIDC.Claim, "email") {
+		if len(email) == 0 || !claims.IsEmailVerified() {
+			return "", ErrorOIDCAccount
+		}
+		return email, nil
+	}
+	return claims.Subject, nil
+}
+
+// New account of the claims (auto_register), the password is not known
+func oidc_user_register(claims *OIDCClaims, value string) *DBUserData {
+	var idx utils.TIDX = 0
+	for i := 0; i < 10; i++ {
+		var v = utils.GenerateIDX(0)
+		if !database_redis.HasKey(db_user_id(v)) {
+			idx = v
+			break
+		}
+	}
+	if idx == 0 {
+		return nil
+	}
+
+	// preferred_username, the local part of the email, or oidc_<hash>
+	var names = []string{}
+	if len(claims.Name) > 0 {
+		names = append(names, claims.Name)
+	}
+	if index := strings.Index(claims.Email, "@"); index > 0 {
+		names = append(names, claims.Email[0:index])
+	}
+	var name = ""
+	for _, v := range append(names, "oidc_"+strings.ToLower(utils.SHA256(value))[0:10]) {
+		v = oidc_name_regex.ReplaceAllString(strings.TrimSpace(v), "_")
+		if user_name_check(v) && database_redis.PushStringNX(db_user_name_id(v), fmt.Sprintf("%d", idx), database_redis.KEEP_TIME) {
+			name = v
+			break
+		}
+	}
+	if len(name) == 0 {
+		return nil
+	}
+
+	var user = &DBUserData{
+		IDX:        idx,
+		Name:       name,
+		Password:   utils.PasswordHash(utils.GenerateToken()),
+		Status:     USER_STATUS_ACTIVE,
+		Role:       ROLE_MEMBER,
+		CreateTime: utils.DateFormat(time.Now(), 3),
+	}
+	if len(user.Password) == 0 || !db_user_save(user) {
+		database_redis.DelWithKey(db_user_name_id(name))
+		return nil
+	}
+	utils.Logger.LogWarning("[Account] Register (oidc) IDX:", user.IDX, " Username:", user.Name)
+	return user
+}
+
+// Account of the claims : config (oidc.users), linked accounts, or registered (auto_register)
+func oidc_user(claims *OIDCClaims) (*DBUserData, error) {
+	value, err := oidc_claim_value(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx utils.TIDX = 0
+	if v, ok := server_config.OIDC.Users[value]; ok {
+		idx = v
+	} else if text, ok := database_redis.GetString(db_oidc_user_id(value)); ok {
+		v, _ := strconv.ParseInt(text, 10, 64)
+		idx = utils.TIDX(v)
+	}
+
+	var user *DBUserData = nil
+	if idx > 0 {
+		user = db_user_get(idx)
+	} else if server_config.OIDC.AutoRegister {
+		user = oidc_user_register(claims, value)
+		if user != nil {
+			database_redis.PushString(db_oidc_user_id(value), fmt.Sprintf("%d", user.IDX), database_redis.KEEP_TIME)
+		}
+	}
+	if user == nil || user.Status == USER_STATUS_DISABLED {
+		return nil, ErrorOIDCAccount
+	}
+	return user, nil
+}
+
+// The state is bound to the browser of the login (HttpOnly cookie of the state hash),
+// a callback of another browser is refused (login CSRF)
+func oidc_state_cookie_set(ctx *gin.Context, state string) {
+	var secure = strings.HasPrefix(strings.ToLower(server_config.OIDC.RedirectUrl), "https://")
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(OIDC_STATE_COOKIE, strings.ToLower(utils.SHA256(state)), OIDC_STATE_TTL, OIDC_STATE_COOKIE_PATH, "", secure, true)
+}
+
+// The cookie is removed
+func oidc_state_cookie_check(ctx *gin.Context, state string) bool {
+	value, err := ctx.Cookie(OIDC_STATE_COOKIE)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(OIDC_STATE_COOKIE, "", -1, OIDC_STATE_COOKIE_PATH, "", false, true)
+	if err != nil || len(value) == 0 || len(state) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(value), []byte(strings.ToLower(utils.SHA256(state)))) == 1
+}
+
+// Redirect to the provider (device_uid), response=json : the authorization url is returned,
+// the callback must be opened by the same browser (cookie of the state)
+func HandleOIDCLogin(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: false})
+	if result < 0 {
+		return
+	}
+
+	if !OIDC_Enabled() {
+		HandleResultFailed(ctx, -100, ErrorOIDCDisabled.Error())
+		return
+	}
+
+	address, state, err := OIDC_AuthorizationUrl(strings.TrimSpace(ctx.Query("device_uid")))
+	if err != nil {
+		utils.Logger.LogError("[Login] OIDC error:", err.Error(), " IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -102, ErrorLoginError.Error())
+		return
+	}
+	oidc_state_cookie_set(ctx, state)
+
+	if ctx.Query("response") == "json" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"url": address,
+		})
+		return
+	}
+	ctx.Redirect(http.StatusFound, address)
+}
+
+// Callback of the provider (code, state), the same login result of /server/login
+// success_url : the result is passed in the url fragment
+func HandleOIDCCallback(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: false})
+	if result < 0 {
+		return
+	}
+
+	if !OIDC_Enabled() {
+		HandleResultFailed(ctx, -100, ErrorOIDCDisabled.Error())
+		return
+	}
+	if value := ctx.Query("error"); len(value) > 0 {
+		utils.Logger.LogWarning("[Login] OIDC error:", value, " (", ctx.Query("error_description"), ")",
+			" IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -101, "oidc login failed: "+value)
+		return
+	}
+
+	if !oidc_state_cookie_check(ctx, strings.TrimSpace(ctx.Query("state"))) {
+		utils.Logger.LogWarning("[Login] OIDC state of another browser IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -101, ErrorOIDCState.Error())
+		return
+	}
+	var state = db_oidc_state_take(ctx.Query("state"))
+	if state == nil {
+		HandleResultFailed(ctx, -101, ErrorOIDCState.Error())
+		return
+	}
+
+	claims, err := OIDC_Exchange(strings.TrimSpace(ctx.Query("code")), state)
+	if err != nil {
+		utils.Logger.LogWarning("[Login] OIDC error:", err.Error(), " IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -102, ErrorOIDCToken.Error())
+		return
+	}
+
+	user, err := oidc_user(claims)
+	if err != nil {
+		utils.Logger.LogWarning("[Login] OIDC Subject:", claims.Subject, " Email:", claims.Email, " not allowed",
+			" IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -103, err.Error())
+		return
+	}
+
+	// Accounts with TOTP get a pending token (/server/auth : otp), unless the provider is trusted (trust_mfa)
+	var pending = user.HasTOTP() && !server_config.OIDC.TrustMFA
+	var result_data = login_result_add(handler, user.IDX, state.DeviceUID, pending)
+	if result_data == nil {
+		HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[Login] OIDC IDX:", user.IDX, " Username:", user.Name, " Subject:", claims.Subject,
+		" IPAddress (", result_data.IPAddress, ",", result_data.DeviceUID, "'", result_data.IPLocalized, "'", ")")
+
+	result_data.IPLocalized = ""
+	if len(server_config.OIDC.SuccessUrl) > 0 {
+		var values = url.Values{}
+		values.Set("idx", fmt.Sprintf("%d", result_data.IDX))
+		values.Set("auth_code", result_data.Code)
+		values.Set("auth_token", result_data.Token)
+		values.Set("expires_in", fmt.Sprintf("%d", result_data.ExpiresIn))
+		values.Set("refresh_token", result_data.RefreshToken)
+		values.Set("refresh_expires_in", fmt.Sprintf("%d", result_data.RefreshExpiresIn))
+		values.Set("device_uid", result_data.DeviceUID)
+		if result_data.Pending {
+			values.Set("pending", "true")
+		}
+		ctx.Redirect(http.StatusFound, server_config.OIDC.SuccessUrl+"#"+values.Encode())
+		return
+	}
+	ctx.JSON(http.StatusOK, result_data)
+}
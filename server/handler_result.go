@@ -43,8 +43,8 @@ func HandleResultFailed2(ctx *gin.Context, data *httpx.HTTPData2) {
 	}
 
 	if data.ErrorCode == http.StatusUnauthorized || data.ErrorCode == http.StatusBadRequest {
-		result := data.Data().(map[string]any)
-		if result == nil {
+		result, ok := data.Data().(map[string]any)
+		if !ok || result == nil {
 			result = map[string]any{
 				"error": nil,
 			}
@@ -65,3 +65,34 @@ func HandleResultFailed2(ctx *gin.Context, data *httpx.HTTPData2) {
 
 	//ctx.Abort()
 }
+
+func HandleResultOpenAIError(ctx *gin.Context, err *OpenAIError) {
+	if err == nil || ctx.IsAborted() {
+		return
+	}
+
+	var status = err.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	var param any = nil
+	if len(err.Param) > 0 {
+		param = err.Param
+	}
+	var code any = nil
+	if len(err.Code) > 0 {
+		code = err.Code
+	}
+
+	ctx.JSON(status, gin.H{
+		"error": gin.H{
+			"message": err.Message,
+			"type":    err.Type,
+			"param":   param,
+			"code":    code,
+		},
+	})
+
+	//ctx.Abort()
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+type TSessionData struct {
+	// Session id (the device uid may be empty)
+	ID          string `json:"id"`
+	DeviceUID   string `json:"device_uid"`
+	CreateTime  string `json:"create_time"`
+	LoginTime   string `json:"login_time"`
+	ExpireTime  string `json:"expire_time"`
+	AuthTime    string `json:"auth_time"`
+	AuthCount   int    `json:"auth_count"`
+	IPAddress   string `json:"ip_address"`
+	IPLocalized string `json:"ip_localized"`
+	// The access token is valid, otherwise only the refresh token
+	Active  bool `json:"active"`
+	Current bool `json:"current"`
+}
+
+func session_id(device_uid string) string {
+	return strings.ToLower(utils.MD5(device_uid))[0:16]
+}
+
+// Devices of the user, the devices without valid tokens are skipped
+func db_session_list(idx utils.TIDX) []*TSessionData {
+	var list = []*TSessionData{}
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(fmt.Sprintf("login_user_%d", idx), &db_login_set, false) {
+		return list
+	}
+
+	var now = utils.DateFormat(time.Now(), 3)
+
+	for _, v := range db_login_set.List {
+		var session = &TSessionData{
+			ID:          session_id(v.DeviceUID),
+			DeviceUID:   v.DeviceUID,
+			CreateTime:  v.CreateTime,
+			LoginTime:   v.LoginTime,
+			ExpireTime:  v.ExpireTime,
+			IPAddress:   v.IPAddress,
+			IPLocalized: v.IPLocalized,
+		}
+
+		var auth_data DBAuthorizationData
+		if database_redis.GetJson(db_auth_id(idx, v.Token), &auth_data, false) {
+			session.Active = true
+			session.AuthTime = auth_data.AuthTime
+			session.AuthCount = auth_data.AuthCount
+		} else if JWT_Enabled() && (len(v.ExpireTime) == 0 || v.ExpireTime > now) && !JWT_Denied(v.Token) {
+			// Signed token (jwt), the authorizations are not counted
+			session.Active = true
+		} else if !database_redis.HasKey(db_refresh_id(idx, v.RefreshToken)) {
+			continue
+		}
+		list = append(list, session)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LoginTime > list[j].LoginTime })
+	return list
+}
+
+// Devices of the user (create time, last authorization, IP and location)
+func HandleUserSessions(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var list = db_session_list(handler.AuthorizationData.IDX)
+	for _, v := range list {
+		v.Current = v.DeviceUID == handler.AuthorizationData.DeviceUID
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   list,
+	})
+}
+
+// Revoke the device (session id or device uid)
+func HandleUserSessionDelete(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var device = strings.TrimSpace(ctx.Param("device"))
+
+	var session *TSessionData = nil
+	for _, v := range db_session_list(idx) {
+		if v.ID == device || (len(v.DeviceUID) > 0 && v.DeviceUID == device) {
+			session = v
+			break
+		}
+	}
+	if session == nil || !db_login_data_remove(idx, session.DeviceUID) {
+		HandleResultFailed(ctx, -101, "session not found")
+		return
+	}
+
+	utils.Logger.LogWarning("[Login] Revoke IDX:", idx, " Device (", session.DeviceUID, ", ", session.IPAddress, ",'", session.IPLocalized, "')",
+		" IPAddress (", handler.RemoteAddress, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":         session.ID,
+		"device_uid": session.DeviceUID,
+		"deleted":    true,
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	OPENAI_USAGE_DAYS     = 30
+	OPENAI_USAGE_DAYS_MAX = 90
+)
+
+func usage_items_list(items map[string]*OpenAIUsageItem, total *OpenAIUsageItem) []*OpenAIUsageItem {
+	var keys = maps.Keys(items)
+	sort.Strings(keys)
+
+	var list = []*OpenAIUsageItem{}
+	for _, k := range keys {
+		var v = items[k]
+		list = append(list, v)
+		if total != nil {
+			total.Requests += v.Requests
+			total.PromptTokens += v.PromptTokens
+			total.CompletionTokens += v.CompletionTokens
+			total.TotalTokens += v.TotalTokens
+			total.Cost += v.Cost
+		}
+	}
+	return list
+}
+
+// Token and cost usage of the user : per day and model (?days=30), and the current month
+func HandleUserUsage(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, RequiredScopes: []string{SCOPE_USAGE}})
+	if result < 0 {
+		return
+	}
+
+	days, err := strconv.Atoi(ctx.DefaultQuery("days", strconv.Itoa(OPENAI_USAGE_DAYS)))
+	if err != nil || days <= 0 {
+		days = OPENAI_USAGE_DAYS
+	}
+	if days > OPENAI_USAGE_DAYS_MAX {
+		days = OPENAI_USAGE_DAYS_MAX
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var now = time.Now()
+
+	var total = &OpenAIUsageItem{Model: "*"}
+	var list = []gin.H{}
+	for i := 0; i < days; i++ {
+		var date = now.AddDate(0, 0, -i)
+		var items = OpenAI_UsageLoad(idx, date.Format("20060102"))
+		if len(items) == 0 {
+			continue
+		}
+		list = append(list, gin.H{
+			"date":   date.Format("2006-01-02"),
+			"models": usage_items_list(items, total),
+		})
+	}
+
+	var month = &OpenAIUsageItem{Model: "*"}
+	var month_items = usage_items_list(OpenAI_UsageLoad(idx, now.Format("200601")), month)
+
+	var plan any = nil
+	if value := UserPlan_Get(idx); value != nil {
+		plan = value
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"object": "usage",
+		"plan":   plan,
+		"idx":    idx,
+		"days":   days,
+		"data":   list,
+		"total":  total,
+		"month": gin.H{
+			"date":   now.Format("2006-01"),
+			"models": month_items,
+			"total":  month,
+		},
+	})
+}
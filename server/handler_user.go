@@ -26,26 +26,39 @@ type TLoginResultData struct {
 	IDX   utils.TIDX `json:"idx"`
 	Code  string     `json:"auth_code"`
 	Token string     `json:"auth_token"`
+	// Seconds
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	// Two-factor authentication required (/server/auth : otp)
+	Pending bool `json:"pending"`
 	// Time
 	Timestamp  int64  `json:"timestamp"` //server timestamp
 	CreateTime string `json:"create_time"`
 	// Device ID
-	IPAddress string `json:"ip_address"`
+	IPAddress   string `json:"ip_address"`
 	IPLocalized string `json:"ip_localized"`
-	DeviceUID string `json:"device_uid"`
+	DeviceUID   string `json:"device_uid"`
 }
 
 type DBLoginData struct {
 	IDX utils.TIDX `json:"idx"`
 	//
-	Code  string `json:"auth_code"`
-	Token string `json:"auth_token"`
+	Code         string `json:"auth_code"`
+	Token        string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
 	//
 	CreateTime string `json:"create_time"`
+	LoginTime  string `json:"login_time"`
+	ExpireTime string `json:"expire_time"`
+	// Two-factor authentication not verified
+	Pending bool `json:"pending"`
+	// Role of the user on login (the tokens are cleared when the role is changed)
+	Role string `json:"role"`
 	// Device ID
-	IPAddress string `json:"ip_address"`
+	IPAddress   string `json:"ip_address"`
 	IPLocalized string `json:"ip_localized"`
-	DeviceUID string `json:"device_uid"`
+	DeviceUID   string `json:"device_uid"`
 }
 
 type DBLoginDataSet struct {
@@ -57,6 +70,8 @@ type DBLoginDataSet struct {
 type TUserAuthData struct {
 	IDX  utils.TIDX `form:"idx" json:"idx"`
 	Code string     `json:"code"`
+	// TOTP code or recovery code of the pending token
+	OTP string `form:"otp" json:"otp"`
 	// Timestamp
 	Timestamp int64 `form:"timestamp" json:"timestamp"` //client timestamp
 	// Device UID
@@ -70,14 +85,61 @@ type TUserAuthResultData struct {
 	// Time
 	Timestamp int64 `json:"timestamp"`
 	// Device ID
-	IPAddress string `json:"ip_address"`
+	IPAddress   string `json:"ip_address"`
 	IPLocalized string `json:"ip_localized"`
 	//DeviceUID string `json:"device_uid"`
 }
 
+const (
+	// Seconds
+	LOGIN_TOKEN_TTL         = utils.TIME_DAY
+	LOGIN_REFRESH_TOKEN_TTL = utils.TIME_30DAY
+)
+
 var ErrorLoginFailed = errors.New("checking login information failed")
 var ErrorLoginAccountInvalidate = errors.New("login account invalidate")
 var ErrorLoginError = errors.New("login internal error")
+var ErrorLoginLocked = errors.New("too many failed login attempts, try again later")
+var ErrorLoginRefresh = errors.New("refresh token invalidate or expiration")
+
+// Refresh token (refresh_user_<idx>_<token>)
+type DBRefreshData struct {
+	IDX        utils.TIDX `json:"idx"`
+	Token      string     `json:"auth_token"`
+	DeviceUID  string     `json:"device_uid"`
+	CreateTime string     `json:"create_time"`
+}
+
+type TRefreshData struct {
+	IDX          utils.TIDX `form:"idx" json:"idx"`
+	RefreshToken string     `form:"refresh_token" json:"refresh_token"`
+}
+
+type TLogoutData struct {
+	// Logout all devices
+	All bool `form:"all" json:"all"`
+}
+
+// Seconds, -1 : never expires
+func login_token_ttl() (float32, float32) {
+	var ttl = float32(server_config.TokenTTL)
+	if ttl == 0 {
+		ttl = LOGIN_TOKEN_TTL
+	}
+	var refresh_ttl = float32(server_config.RefreshTokenTTL)
+	if refresh_ttl == 0 {
+		refresh_ttl = LOGIN_REFRESH_TOKEN_TTL
+	}
+	return ttl, refresh_ttl
+}
+
+func db_auth_id(idx utils.TIDX, token string) string {
+	return fmt.Sprintf("auth_user_%d_%s", idx, token)
+}
+
+func db_refresh_id(idx utils.TIDX, token string) string {
+	return fmt.Sprintf("refresh_user_%d_%s", idx, token)
+}
 
 func HandleUserLogin(ctx *gin.Context) {
 	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: false})
@@ -100,45 +162,95 @@ func HandleUserLogin(ctx *gin.Context) {
 	}
 
 	login_data.DeviceUID = strings.TrimSpace(login_data.DeviceUID)
+	login_data.Username = strings.TrimSpace(login_data.Username)
+	if login_data.IDX == 0 {
+		login_data.IDX = db_user_find(login_data.Username)
+	}
 	if !utils.CheckAccountIDX(login_data.IDX, 6, 12) {
 		HandleResultFailed(ctx, -101, ErrorLoginFailed.Error())
 		return
 	}
 
+	// Too many failed attempts (IDX or IP)
+	if login_locked(login_data.IDX, handler.RemoteAddress) {
+		utils.Logger.LogWarning("[Login] IDX:", login_data.IDX, " locked IPAddress (", handler.RemoteAddress, ")")
+		HandleResultFailed(ctx, -103, ErrorLoginLocked.Error())
+		return
+	}
+
+	// Checking account and password
+	var user = db_user_get(login_data.IDX)
+	if !user.Verify(login_data.Password) ||
+		(len(login_data.Username) > 0 && !strings.EqualFold(login_data.Username, user.Name)) {
+		login_failed(login_data.IDX, handler.RemoteAddress)
+		HandleResultFailed(ctx, -101, ErrorLoginFailed.Error())
+		return
+	}
+	login_succeeded(login_data.IDX)
+
 	//User login
+	var result_data = login_result_add(handler, login_data.IDX, login_data.DeviceUID, user.HasTOTP())
+	if result_data == nil {
+		HandleResultFailed(ctx, -102, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[Login] Username:", login_data.Username, " IDX:", result_data.IDX,
+		" Result (OK)",
+		" IPAddress (", result_data.IPAddress, ",", result_data.DeviceUID, "'", result_data.IPLocalized, "'", ")")
+
+	//
+	result_data.IPLocalized = ""
+	//
+	ctx.JSON(http.StatusOK, result_data)
+}
+
+// Login result of the device (password or oidc), the tokens of the device are added
+func login_result_add(handler *Handler, idx utils.TIDX, device_uid string, pending bool) *TLoginResultData {
 	var result_data TLoginResultData = TLoginResultData{
-		IDX:        login_data.IDX,
+		IDX:        idx,
 		Timestamp:  int64(utils.GetTimeStamp64()),
 		CreateTime: utils.DateFormat(time.Now(), 3),
 	}
 
-	result_data.DeviceUID = login_data.DeviceUID
+	result_data.DeviceUID = device_uid
 	result_data.IPAddress = handler.RemoteAddress
 	result_data.IPLocalized = IPLocalized(handler.RemoteAddress).Localize()
 	//
 	result_data.Code = utils.GenerateCode(3)
-
-	var token_rand = utils.GenerateCode(0)
-	var token_text = fmt.Sprintf("%d_%d_%s", result_data.IDX, utils.GetTimeStamp64(), token_rand)
-	result_data.Token = utils.SHA256(token_text)
+	result_data.Pending = pending
 
 	//
 	if db_login_data_add(&result_data) == nil {
-		HandleResultFailed(ctx, -102, ErrorLoginError.Error())
-		return
+		return nil
 	}
+	return &result_data
+}
 
-	utils.Logger.LogWarning("[Login] Username:", login_data.Username,
-		" Result (", result_data.Code, ", ", result_data.Token, ")",
-		" IPAddress (", result_data.IPAddress, ",", result_data.DeviceUID, "'", result_data.IPLocalized,"'",")")
-
-	//
-	result_data.IPLocalized = ""
-	//
-	ctx.JSON(http.StatusOK, result_data)
+// Remove the access and refresh tokens of the device, the signed tokens (jwt) are denied until expired
+func db_login_token_revoke(idx utils.TIDX, val *DBLoginData) {
+	database_redis.DelWithKey(db_auth_id(idx, val.Token))
+	database_redis.DelWithKey(db_refresh_id(idx, val.RefreshToken))
+	if JWT_Enabled() {
+		JWT_Deny(val.Token)
+	}
 }
 
+// New access and refresh tokens of the device, the previous tokens of the device are removed
+// jwt_enabled : the access token is a signed token (jwt), the token id (jti) is stored as the device token
 func db_login_data_add(data *TLoginResultData) *DBLoginDataSet {
+	var ttl, refresh_ttl = login_token_ttl()
+
+	data.Token = utils.GenerateToken()
+	data.RefreshToken = utils.GenerateToken()
+	data.ExpiresIn = int64(ttl)
+	data.RefreshExpiresIn = int64(refresh_ttl)
+	if JWT_Enabled() {
+		_, data.ExpiresIn = login_token_ttl_jwt()
+	}
+	if len(data.Token) == 0 || len(data.RefreshToken) == 0 {
+		return nil
+	}
 
 	var db_id = fmt.Sprintf("login_user_%d", data.IDX)
 	var db_login_set DBLoginDataSet
@@ -159,12 +271,34 @@ func db_login_data_add(data *TLoginResultData) *DBLoginDataSet {
 			CreateTime: utils.DateFormat(time.Now(), 3),
 			DeviceUID:  data.DeviceUID,
 		}
+	} else {
+		db_login_token_revoke(data.IDX, &val)
 	}
 
 	val.Code = data.Code
 	val.Token = data.Token
+	val.RefreshToken = data.RefreshToken
 	val.IPAddress = data.IPAddress
 	val.IPLocalized = data.IPLocalized
+	val.Pending = data.Pending
+	val.Role = user_role(data.IDX)
+	val.LoginTime = utils.DateFormat(time.Now(), 3)
+	val.ExpireTime = ""
+	if ttl > 0 {
+		val.ExpireTime = utils.DateFormat(time.Now().Add(time.Duration(ttl)*time.Second), 3)
+	}
+
+	// Signed token, the authorization data is not stored
+	var jwt_token = ""
+	if JWT_Enabled() {
+		val.Token = strings.ToLower(data.Token[0:32])
+		value, err := login_jwt_sign(&val)
+		if err != nil {
+			utils.Logger.LogError("[Login] IDX:", val.IDX, " JWT error:", err.Error())
+			return nil
+		}
+		jwt_token = value
+	}
 
 	db_login_set.List[key] = val
 
@@ -175,27 +309,159 @@ func db_login_data_add(data *TLoginResultData) *DBLoginDataSet {
 
 	// generate authorization data
 	var auth_data DBAuthorizationData = DBAuthorizationData{
-		IDX:        val.IDX,
-		Code:       val.Code,
-		Token:      val.Token,
-		CreateTime: val.CreateTime,
-		DeviceUID:  val.DeviceUID,
-		IPAddress:  val.IPAddress,
+		IDX:         val.IDX,
+		Code:        val.Code,
+		Token:       val.Token,
+		CreateTime:  val.CreateTime,
+		DeviceUID:   val.DeviceUID,
+		IPAddress:   val.IPAddress,
 		IPLocalized: val.IPAddress,
+		Pending:     val.Pending,
+		Role:        val.Role,
 	}
 	auth_data.AuthTime = utils.DateFormat(time.Now(), 3)
 	auth_data.AuthCount = 1
 
-	var db_auth_id = fmt.Sprintf("auth_user_%d_%s", data.IDX, auth_data.Token)
-	if !database_redis.PushJson[DBAuthorizationData](db_auth_id, &auth_data, database_redis.KEEP_TIME, false) {
+	if len(jwt_token) > 0 {
+		data.Token = jwt_token
+	} else if !database_redis.PushJson[DBAuthorizationData](db_auth_id(data.IDX, auth_data.Token), &auth_data, ttl, false) {
+		return nil
+	}
+
+	var refresh_data DBRefreshData = DBRefreshData{
+		IDX:        val.IDX,
+		Token:      val.Token,
+		DeviceUID:  val.DeviceUID,
+		CreateTime: utils.DateFormat(time.Now(), 3),
+	}
+	if !database_redis.PushJson[DBRefreshData](db_refresh_id(data.IDX, val.RefreshToken), &refresh_data, refresh_ttl, false) {
 		return nil
 	}
 
 	return &db_login_set
 }
 
+// Signed token (jwt) of the device, the token id (jti) is the device token
+func login_jwt_sign(val *DBLoginData) (string, error) {
+	_, ttl := login_token_ttl_jwt()
+	var now = time.Now()
+	var claims = &JWTClaims{
+		Issuer:    server_config.JWTIssuer,
+		Subject:   fmt.Sprintf("%d", val.IDX),
+		IDX:       val.IDX,
+		DeviceUID: val.DeviceUID,
+		ID:        val.Token,
+		IssuedAt:  now.Unix(),
+		Pending:   val.Pending,
+		Role:      val.Role,
+		ExpiresAt: now.Unix() + ttl,
+	}
+	return JWT_Sign(claims)
+}
+
+// The two-factor authentication of the device is verified, the signed token (jwt) is issued again
+func db_login_data_verified(idx utils.TIDX, device_uid string, token string) (string, bool) {
+	var db_id = fmt.Sprintf("login_user_%d", idx)
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(db_id, &db_login_set, false) {
+		return "", false
+	}
+	val, ok := db_login_set.List[device_uid]
+	if !ok || len(token) == 0 || val.Token != token {
+		return "", false
+	}
+
+	val.Pending = false
+	var access_token = token
+	if JWT_Enabled() {
+		JWT_Deny(val.Token)
+		val.Token = strings.ToLower(utils.GenerateToken()[0:32])
+		value, err := login_jwt_sign(&val)
+		if err != nil {
+			return "", false
+		}
+		access_token = value
+	} else {
+		var auth_data DBAuthorizationData
+		if !database_redis.GetJson(db_auth_id(idx, token), &auth_data, false) {
+			return "", false
+		}
+		auth_data.Pending = false
+		if !database_redis.UpdateJson[DBAuthorizationData](db_auth_id(idx, token), &auth_data, false) {
+			return "", false
+		}
+	}
+
+	db_login_set.List[device_uid] = val
+	if !database_redis.PushJson[DBLoginDataSet](db_id, &db_login_set, database_redis.KEEP_TIME, false) {
+		return "", false
+	}
+	return access_token, true
+}
+
+// Remove the tokens of the device
+func db_login_data_remove(idx utils.TIDX, device_uid string) bool {
+	var db_id = fmt.Sprintf("login_user_%d", idx)
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(db_id, &db_login_set, false) {
+		return false
+	}
+
+	val, ok := db_login_set.List[device_uid]
+	if !ok {
+		return false
+	}
+	db_login_token_revoke(idx, &val)
+	delete(db_login_set.List, device_uid)
+
+	if len(db_login_set.List) == 0 {
+		return database_redis.DelWithKey(db_id)
+	}
+	return database_redis.PushJson[DBLoginDataSet](db_id, &db_login_set, database_redis.KEEP_TIME, false)
+}
+
+// Remove the tokens of all devices (except the token), the tokens are invalidated immediately
+func db_login_data_clear(idx utils.TIDX, token string) int {
+	var db_id = fmt.Sprintf("login_user_%d", idx)
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(db_id, &db_login_set, false) {
+		return 0
+	}
+
+	var count = 0
+	for k, v := range db_login_set.List {
+		if len(token) > 0 && v.Token == token {
+			continue
+		}
+		db_login_token_revoke(idx, &v)
+		delete(db_login_set.List, k)
+		count++
+	}
+
+	if len(db_login_set.List) == 0 {
+		database_redis.DelWithKey(db_id)
+	} else {
+		database_redis.PushJson[DBLoginDataSet](db_id, &db_login_set, database_redis.KEEP_TIME, false)
+	}
+	return count
+}
+
+// Code of the device login, the token is the current token of the device
+func db_login_code(idx utils.TIDX, device_uid string, token string) string {
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(fmt.Sprintf("login_user_%d", idx), &db_login_set, false) {
+		return ""
+	}
+	val, ok := db_login_set.List[device_uid]
+	if !ok || len(token) == 0 || val.Token != token {
+		return ""
+	}
+	return val.Code
+}
+
+// The pending token (two-factor authentication) is verified by the otp (TOTP code or recovery code)
 func HandleUserAuth(ctx *gin.Context) {
-	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true})
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, AllowPending: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
 	if result < 0 {
 		return
 	}
@@ -215,20 +481,53 @@ func HandleUserAuth(ctx *gin.Context) {
 		return
 	}
 
+	// The code of the signed token (jwt) is not in the token
+	var code = handler.AuthorizationData.AuthCode
+	if len(code) == 0 && handler.AuthorizationData.APIKey == nil {
+		code = db_login_code(handler.AuthorizationData.IDX, handler.AuthorizationData.DeviceUID, handler.AuthorizationData.AuthToken)
+	}
+
 	auth_data.Code = strings.TrimSpace(auth_data.Code)
-	if auth_data.Code != handler.AuthorizationData.AuthCode {
+	if len(code) == 0 || auth_data.Code != code {
 		HandleResultFailed(ctx, -101, errors.New("user authorization failed").Error())
 		return
 	}
 
+	var token = handler.AuthorizationData.AuthToken
+	if text := authorization_text(handler.GetHeader("Authorization", "")); IsJWT(text) {
+		token = text
+	}
+
+	if handler.AuthorizationData.Pending {
+		var idx = handler.AuthorizationData.IDX
+		if login_locked(idx, handler.RemoteAddress) {
+			HandleResultFailed(ctx, -103, ErrorLoginLocked.Error())
+			return
+		}
+		var user = db_user_get(idx)
+		if user == nil || user.Status == USER_STATUS_DISABLED || !user.VerifyTOTP(auth_data.OTP) {
+			login_failed(idx, handler.RemoteAddress)
+			HandleResultFailed(ctx, -102, Error2FAFailed.Error())
+			return
+		}
+		login_succeeded(idx)
+
+		value, ok := db_login_data_verified(idx, handler.AuthorizationData.DeviceUID, handler.AuthorizationData.AuthToken)
+		if !ok {
+			HandleResultFailed(ctx, -104, ErrorLoginError.Error())
+			return
+		}
+		token = value
+	}
+
 	utils.Logger.LogWarning("[Auth] IDX:", auth_data.IDX,
 		" Result (OK)",
 		" IPAddress (", handler.AuthorizationData.IPAddress, ",", handler.AuthorizationData.DeviceUID, ",'", handler.AuthorizationData.IPLocalized, "')")
 
 	var result_data TUserAuthResultData = TUserAuthResultData{
 		IDX:       handler.AuthorizationData.IDX,
-		Code:      handler.AuthorizationData.AuthCode,
-		Token:     handler.AuthorizationData.AuthToken,
+		Code:      code,
+		Token:     token,
 		Timestamp: int64(utils.GetTimeStamp64()),
 	}
 	result_data.IPAddress = handler.AuthorizationData.IPAddress
@@ -236,3 +535,122 @@ func HandleUserAuth(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, result_data)
 }
+
+// New access token of the refresh token (idx, refresh_token), the refresh token is rotated
+func HandleUserTokenRefresh(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: false})
+	if result < 0 {
+		return
+	}
+
+	var err error = nil
+	var refresh_data TRefreshData = TRefreshData{}
+	if handler.Method == http.MethodPost {
+		err = handler.GetData(&refresh_data)
+	} else {
+		err = handler.GetParamters(&refresh_data)
+	}
+	if err != nil {
+		HandleResultFailed(ctx, -100, err.Error())
+		return
+	}
+
+	refresh_data.RefreshToken = strings.TrimSpace(refresh_data.RefreshToken)
+	if !utils.CheckAccountIDX(refresh_data.IDX, 6, 12) || !utils.CheckToken(refresh_data.RefreshToken) {
+		HandleResultFailed(ctx, -101, ErrorLoginRefresh.Error())
+		return
+	}
+
+	var db_id = db_refresh_id(refresh_data.IDX, refresh_data.RefreshToken)
+	var db_refresh_data DBRefreshData
+	if !database_redis.GetJson(db_id, &db_refresh_data, false) || db_refresh_data.IDX != refresh_data.IDX {
+		HandleResultFailed(ctx, -101, ErrorLoginRefresh.Error())
+		return
+	}
+
+	// The account is disabled or deleted
+	var user = db_user_get(refresh_data.IDX)
+	if user == nil || user.Status == USER_STATUS_DISABLED {
+		db_login_data_clear(refresh_data.IDX, "")
+		HandleResultFailed(ctx, -101, ErrorLoginRefresh.Error())
+		return
+	}
+
+	var db_login_set DBLoginDataSet
+	if !database_redis.GetJson(fmt.Sprintf("login_user_%d", refresh_data.IDX), &db_login_set, false) {
+		HandleResultFailed(ctx, -101, ErrorLoginRefresh.Error())
+		return
+	}
+	val, ok := db_login_set.List[db_refresh_data.DeviceUID]
+	if !ok || val.RefreshToken != refresh_data.RefreshToken {
+		database_redis.DelWithKey(db_id)
+		HandleResultFailed(ctx, -101, ErrorLoginRefresh.Error())
+		return
+	}
+
+	var result_data TLoginResultData = TLoginResultData{
+		IDX:        refresh_data.IDX,
+		Code:       val.Code,
+		Pending:    val.Pending,
+		Timestamp:  int64(utils.GetTimeStamp64()),
+		CreateTime: val.CreateTime,
+		DeviceUID:  val.DeviceUID,
+	}
+	result_data.IPAddress = handler.RemoteAddress
+	result_data.IPLocalized = IPLocalized(handler.RemoteAddress).Localize()
+
+	if db_login_data_add(&result_data) == nil {
+		HandleResultFailed(ctx, -102, ErrorLoginError.Error())
+		return
+	}
+
+	utils.Logger.LogWarning("[Login] Refresh IDX:", result_data.IDX,
+		" IPAddress (", result_data.IPAddress, ",", result_data.DeviceUID, ",'", result_data.IPLocalized, "')")
+
+	result_data.IPLocalized = ""
+	ctx.JSON(http.StatusOK, result_data)
+}
+
+// Remove the tokens of the device, or all devices (all=true)
+func HandleUserLogout(ctx *gin.Context) {
+	result, handler := InitHandler(ctx, &HandlerOptions{HasAuthorization: true, AllowPending: true, RequiredScopes: []string{SCOPE_ACCOUNT}})
+	if result < 0 {
+		return
+	}
+
+	var logout_data TLogoutData = TLogoutData{}
+	if handler.Data != nil {
+		handler.GetData(&logout_data)
+	}
+	if !logout_data.All {
+		logout_data.All = ctx.Query("all") == "true" || ctx.Query("all") == "1"
+	}
+
+	var idx = handler.AuthorizationData.IDX
+	var count = 1
+	if logout_data.All {
+		count = db_login_data_clear(idx, "")
+	} else if !db_login_data_remove(idx, handler.AuthorizationData.DeviceUID) {
+		// Not in the devices
+		database_redis.DelWithKey(db_auth_id(idx, handler.AuthorizationData.AuthToken))
+		if JWT_Enabled() {
+			JWT_Deny(handler.AuthorizationData.AuthToken)
+		}
+	}
+
+	utils.Logger.LogWarning("[Login] Logout IDX:", idx, " Devices (", count, ")",
+		" IPAddress (", handler.RemoteAddress, ",", handler.AuthorizationData.DeviceUID, ")")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"idx":     idx,
+		"devices": count,
+	})
+}
+
+// Public keys of the signed tokens (jwt_enabled, EdDSA), the previous keys are listed until rotated out
+func HandleUserJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, gin.H{
+		"keys": JWT_JWKS(),
+	})
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	JWT_ALG_EDDSA = "EdDSA"
+	JWT_ALG_HS256 = "HS256"
+	// Seconds
+	JWT_ROTATE = 7 * 24 * 3600
+	JWT_RELOAD = 60
+	// Signing keys (shared by all instances), denylist : jwt_deny_<jti>
+	JWT_KEYS_ID      = "jwt_keys"
+	JWT_KEYS_LOCK_ID = "jwt_keys_lock"
+)
+
+var ErrorJWTInvalidate = errors.New("jwt invalidate")
+var ErrorJWTExpired = errors.New("jwt expiration")
+
+// Signing key, secret : ed25519 private key (seed) or HMAC secret
+type DBJWTKey struct {
+	Kid        string `json:"kid"`
+	Alg        string `json:"alg"`
+	Secret     string `json:"secret"`
+	CreateTime int64  `json:"create_time"`
+}
+
+type DBJWTKeySet struct {
+	Keys []DBJWTKey `json:"keys"`
+}
+
+type JWTClaims struct {
+	Issuer    string     `json:"iss,omitempty"`
+	Subject   string     `json:"sub"`
+	IDX       utils.TIDX `json:"idx"`
+	DeviceUID string     `json:"dev"`
+	ID        string     `json:"jti"`
+	IssuedAt  int64      `json:"iat"`
+	ExpiresAt int64      `json:"exp"`
+	// Two-factor authentication not verified
+	Pending bool   `json:"pending,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+type jwt_keys struct {
+	lock   sync.RWMutex
+	keys   []DBJWTKey
+	loaded time.Time
+}
+
+// Cached keys of the signing and verification, reloaded every JWT_RELOAD seconds
+var jwt_cache = &jwt_keys{}
+
+func JWT_Enabled() bool {
+	return server_config.JWTEnabled
+}
+
+func jwt_algorithm() string {
+	if strings.EqualFold(server_config.JWTAlgorithm, JWT_ALG_HS256) {
+		return JWT_ALG_HS256
+	}
+	return JWT_ALG_EDDSA
+}
+
+func jwt_rotate() int64 {
+	if server_config.JWTRotate > 0 {
+		return int64(server_config.JWTRotate)
+	}
+	return JWT_ROTATE
+}
+
+func JWT_Init(config Config) bool {
+	if !config.JWTEnabled {
+		return true
+	}
+
+	if !jwt_keys_load(true) {
+		utils.Logger.LogError("[JWT] Signing keys loading failure.")
+		return false
+	}
+	utils.Logger.Log("[JWT] (", jwt_algorithm(), ", Keys:", len(jwt_cache.keys), ", Rotate:", jwt_rotate(), "s)")
+	return true
+}
+
+func jwt_key_new(alg string) *DBJWTKey {
+	var secret []byte
+	if alg == JWT_ALG_HS256 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil
+		}
+	} else {
+		_, private_key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil
+		}
+		secret = private_key.Seed()
+	}
+	return &DBJWTKey{
+		Kid:        strings.ToLower(utils.GenerateToken()[0:16]),
+		Alg:        alg,
+		Secret:     base64.RawURLEncoding.EncodeToString(secret),
+		CreateTime: time.Now().Unix(),
+	}
+}
+
+// The newest key signs, the previous keys verify until their tokens are expired
+// rotate : a new key is added when the newest key is older than jwt_rotate
+func jwt_keys_load(rotate bool) bool {
+	var alg = jwt_algorithm()
+	var now = time.Now().Unix()
+	var db_keys DBJWTKeySet
+	database_redis.GetJson(JWT_KEYS_ID, &db_keys, false)
+
+	var expired = len(db_keys.Keys) == 0 || db_keys.Keys[0].Alg != alg ||
+		now-db_keys.Keys[0].CreateTime >= jwt_rotate()
+	if rotate && expired && database_redis.PushStringNX(JWT_KEYS_LOCK_ID, "1", 10) {
+		var key = jwt_key_new(alg)
+		if key == nil {
+			database_redis.DelWithKey(JWT_KEYS_LOCK_ID)
+			return false
+		}
+
+		_, ttl := login_token_ttl_jwt()
+		var keys = []DBJWTKey{*key}
+		for _, v := range db_keys.Keys {
+			// Tokens signed by the key are expired
+			if v.Alg == alg && now-v.CreateTime < jwt_rotate()+ttl {
+				keys = append(keys, v)
+			}
+		}
+		db_keys.Keys = keys
+		if !database_redis.PushJson[DBJWTKeySet](JWT_KEYS_ID, &db_keys, database_redis.KEEP_TIME, false) {
+			database_redis.DelWithKey(JWT_KEYS_LOCK_ID)
+			return false
+		}
+		database_redis.DelWithKey(JWT_KEYS_LOCK_ID)
+		utils.Logger.LogWarning("[JWT] Signing key rotated (", key.Kid, ")")
+	}
+
+	if len(db_keys.Keys) == 0 {
+		return false
+	}
+
+	jwt_cache.lock.Lock()
+	jwt_cache.keys = db_keys.Keys
+	jwt_cache.loaded = time.Now()
+	jwt_cache.lock.Unlock()
+	return true
+}
+
+// Access token TTL (seconds) of the signed tokens, the signed tokens always expire
+// (token_ttl <= 0 : one day), the denylist entries and the old keys are kept until then
+func login_token_ttl_jwt() (float32, int64) {
+	ttl, _ := login_token_ttl()
+	if ttl <= 0 {
+		return ttl, int64(LOGIN_TOKEN_TTL)
+	}
+	return ttl, int64(ttl)
+}
+
+// Key of the kid (empty : the signing key), the cached keys are reloaded (rotate) when they are out of date
+func jwt_key(kid string) *DBJWTKey {
+	if !JWT_Enabled() {
+		return nil
+	}
+
+	jwt_cache.lock.RLock()
+	var keys = jwt_cache.keys
+	var reload = time.Since(jwt_cache.loaded) >= JWT_RELOAD*time.Second
+	jwt_cache.lock.RUnlock()
+
+	if reload {
+		jwt_keys_load(true)
+		jwt_cache.lock.RLock()
+		keys = jwt_cache.keys
+		jwt_cache.lock.RUnlock()
+	}
+
+	for i, v := range keys {
+		if len(kid) == 0 || v.Kid == kid {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+func (I *DBJWTKey) sign(data []byte) []byte {
+	secret, err := base64.RawURLEncoding.DecodeString(I.Secret)
+	if err != nil {
+		return nil
+	}
+	if I.Alg == JWT_ALG_HS256 {
+		var mac = hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	if len(secret) != ed25519.SeedSize {
+		return nil
+	}
+	return ed25519.Sign(ed25519.NewKeyFromSeed(secret), data)
+}
+
+func (I *DBJWTKey) verify(data []byte, signature []byte) bool {
+	if I.Alg == JWT_ALG_HS256 {
+		return hmac.Equal(I.sign(data), signature)
+	}
+	var public_key = I.PublicKey()
+	if public_key == nil {
+		return false
+	}
+	return ed25519.Verify(public_key, data, signature)
+}
+
+func (I *DBJWTKey) PublicKey() ed25519.PublicKey {
+	secret, err := base64.RawURLEncoding.DecodeString(I.Secret)
+	if err != nil || len(secret) != ed25519.SeedSize {
+		return nil
+	}
+	return ed25519.NewKeyFromSeed(secret).Public().(ed25519.PublicKey)
+}
+
+// "<header>.<claims>.<signature>"
+func IsJWT(text string) bool {
+	return strings.HasPrefix(text, "eyJ") && strings.Count(text, ".") == 2
+}
+
+func JWT_Sign(claims *JWTClaims) (string, error) {
+	var key = jwt_key("")
+	if key == nil {
+		return "", ErrorJWTInvalidate
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": key.Alg, "typ": "JWT", "kid": key.Kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	var text = base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature = key.sign([]byte(text))
+	if signature == nil {
+		return "", ErrorJWTInvalidate
+	}
+	return text + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Signature, expiry and issuer are verified locally, the denylist is checked in Redis
+func JWT_Verify(text string) (*JWTClaims, error) {
+	var values = strings.Split(text, ".")
+	if len(values) != 3 {
+		return nil, ErrorJWTInvalidate
+	}
+
+	header_data, err := base64.RawURLEncoding.DecodeString(values[0])
+	if err != nil {
+		return nil, ErrorJWTInvalidate
+	}
+	var header map[string]string
+	if json.Unmarshal(header_data, &header) != nil || len(header["kid"]) == 0 {
+		return nil, ErrorJWTInvalidate
+	}
+
+	var key = jwt_key(header["kid"])
+	if key == nil {
+		// Rotated by other instances
+		jwt_keys_load(false)
+		key = jwt_key(header["kid"])
+	}
+	if key == nil || key.Alg != header["alg"] {
+		return nil, ErrorJWTInvalidate
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(values[2])
+	if err != nil || !key.verify([]byte(values[0]+"."+values[1]), signature) {
+		return nil, ErrorJWTInvalidate
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(values[1])
+	if err != nil {
+		return nil, ErrorJWTInvalidate
+	}
+	var claims JWTClaims
+	if json.Unmarshal(payload, &claims) != nil || claims.Subject != strconv.FormatInt(int64(claims.IDX), 10) {
+		return nil, ErrorJWTInvalidate
+	}
+	if claims.ExpiresAt <= 0 || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrorJWTExpired
+	}
+	if len(server_config.JWTIssuer) > 0 && claims.Issuer != server_config.JWTIssuer {
+		return nil, ErrorJWTInvalidate
+	}
+	if JWT_Denied(claims.ID) {
+		return nil, ErrorJWTExpired
+	}
+	return &claims, nil
+}
+
+// Denylist of the revoked tokens (jwt_deny_<jti>)
+func db_jwt_deny_id(jti string) string {
+	return fmt.Sprintf("jwt_deny_%s", jti)
+}
+
+// Revoke the token until it is expired
+func JWT_Deny(jti string) bool {
+	if len(jti) == 0 {
+		return false
+	}
+	_, ttl := login_token_ttl_jwt()
+	return database_redis.PushString(db_jwt_deny_id(jti), "1", float32(ttl))
+}
+
+func JWT_Denied(jti string) bool {
+	return database_redis.HasKey(db_jwt_deny_id(jti))
+}
+
+// Public keys (EdDSA), the HMAC secrets are not published
+func JWT_JWKS() []map[string]string {
+	var list = []map[string]string{}
+	if jwt_key("") == nil {
+		return list
+	}
+
+	jwt_cache.lock.RLock()
+	defer jwt_cache.lock.RUnlock()
+
+	for _, v := range jwt_cache.keys {
+		if v.Alg != JWT_ALG_EDDSA {
+			continue
+		}
+		var public_key = v.PublicKey()
+		if public_key == nil {
+			continue
+		}
+		list = append(list, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"use": "sig",
+			"alg": v.Alg,
+			"kid": v.Kid,
+			"x":   base64.RawURLEncoding.EncodeToString(public_key),
+		})
+	}
+	return list
+}
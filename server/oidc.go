@@ -0,0 +1,479 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Seconds
+	OIDC_STATE_TTL     = 600
+	OIDC_DISCOVERY_TTL = 3600
+	OIDC_CLOCK_SKEW    = 60
+	OIDC_TIMEOUT       = 15
+	// Browser of the login (hash of the state), path of the callback
+	OIDC_STATE_COOKIE      = "oidc_state"
+	OIDC_STATE_COOKIE_PATH = "/server/oidc"
+)
+
+// OpenID Connect provider (config.yaml : oidc)
+//
+//	oidc:
+//	  issuer: "https://id.example.com"
+//	  client_id: "gpt-server"
+//	  client_secret: "..."
+//	  redirect_url: "https://gpt.example.com/server/oidc/callback"
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer" json:"issuer"`
+	ClientID     string `yaml:"client_id" json:"client_id"`
+	ClientSecret string `yaml:"client_secret" json:"client_secret"`
+	RedirectUrl  string `yaml:"redirect_url" json:"redirect_url"`
+	// Default: openid email profile
+	Scopes []string `yaml:"scopes" json:"scopes"`
+	// Claim of the account mapping, "sub" (default) or "email" (email_verified)
+	Claim string `yaml:"claim" json:"claim"`
+	// Accounts of the claim values (existing accounts), the other values are registered (auto_register)
+	Users        map[string]utils.TIDX `yaml:"users" json:"users"`
+	AutoRegister bool                  `yaml:"auto_register" json:"auto_register"`
+	// Email domains of the logins (claim : email), empty : all domains
+	AllowDomains []string `yaml:"allow_domains" json:"allow_domains"`
+	// The login result is passed in the url fragment, otherwise the result is returned (json)
+	SuccessUrl string `yaml:"success_url" json:"success_url"`
+	// The two-factor authentication of the provider is trusted, the TOTP of the account is not verified
+	TrustMFA bool `yaml:"trust_mfa" json:"trust_mfa"`
+}
+
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSUri               string `json:"jwks_uri"`
+}
+
+type OIDCJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC, OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Login state (oidc_state_<state>), removed on the callback
+type DBOIDCState struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	DeviceUID    string `json:"device_uid"`
+	CreateTime   string `json:"create_time"`
+}
+
+type OIDCClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Audience      any    `json:"aud"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"preferred_username"`
+}
+
+type oidc_provider struct {
+	lock      sync.Mutex
+	discovery *OIDCDiscovery
+	keys      map[string]any
+	loaded    time.Time
+	// Unknown kid, the keys are loaded again (rotation)
+	keys_loaded time.Time
+}
+
+var oidc_cache = &oidc_provider{}
+
+var ErrorOIDCDisabled = errors.New("oidc login not enabled")
+var ErrorOIDCState = errors.New("oidc state invalidate or expiration")
+var ErrorOIDCToken = errors.New("oidc id token invalidate")
+var ErrorOIDCAccount = errors.New("oidc account not allowed")
+
+func OIDC_Enabled() bool {
+	return len(server_config.OIDC.Issuer) > 0 && len(server_config.OIDC.ClientID) > 0
+}
+
+func oidc_scopes() string {
+	var scopes = server_config.OIDC.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return strings.Join(scopes, " ")
+}
+
+func oidc_get(address string, data any) error {
+	var client = http.Client{
+		Timeout: OIDC_TIMEOUT * time.Second,
+	}
+	response, err := client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("oidc request failed: " + response.Status)
+	}
+	buffer, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buffer, data)
+}
+
+// Discovery document and keys of the provider, cached (OIDC_DISCOVERY_TTL)
+// reload : the keys are loaded again (kid not found), once a minute
+func oidc_provider_load(reload bool) (*OIDCDiscovery, map[string]any, error) {
+	oidc_cache.lock.Lock()
+	defer oidc_cache.lock.Unlock()
+
+	var now = time.Now()
+	if oidc_cache.discovery == nil || now.Sub(oidc_cache.loaded) >= OIDC_DISCOVERY_TTL*time.Second {
+		var issuer = strings.TrimRight(server_config.OIDC.Issuer, "/")
+		var discovery OIDCDiscovery
+		if err := oidc_get(issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, nil, err
+		}
+		if strings.TrimRight(discovery.Issuer, "/") != issuer || len(discovery.AuthorizationEndpoint) == 0 ||
+			len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSUri) == 0 {
+			return nil, nil, errors.New("oidc discovery invalidate")
+		}
+		oidc_cache.discovery = &discovery
+		oidc_cache.keys = nil
+		oidc_cache.loaded = now
+	}
+
+	if oidc_cache.keys == nil || (reload && now.Sub(oidc_cache.keys_loaded) >= time.Minute) {
+		var jwks struct {
+			Keys []OIDCJWK `json:"keys"`
+		}
+		if err := oidc_get(oidc_cache.discovery.JWKSUri, &jwks); err != nil {
+			return nil, nil, err
+		}
+		var keys = map[string]any{}
+		for _, v := range jwks.Keys {
+			if len(v.Use) > 0 && v.Use != "sig" {
+				continue
+			}
+			if key := v.PublicKey(); key != nil {
+				keys[v.Kid] = key
+			}
+		}
+		oidc_cache.keys = keys
+		oidc_cache.keys_loaded = now
+	}
+	return oidc_cache.discovery, oidc_cache.keys, nil
+}
+
+func oidc_base64_int(text string) *big.Int {
+	buffer, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil || len(buffer) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(buffer)
+}
+
+// RSA, EC (P-256, P-384) and OKP (Ed25519) keys
+func (I *OIDCJWK) PublicKey() any {
+	switch I.Kty {
+	case "RSA":
+		var n, e = oidc_base64_int(I.N), oidc_base64_int(I.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch I.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil
+		}
+		var x, y = oidc_base64_int(I.X), oidc_base64_int(I.Y)
+		if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case "OKP":
+		buffer, err := base64.RawURLEncoding.DecodeString(I.X)
+		if I.Crv != "Ed25519" || err != nil || len(buffer) != ed25519.PublicKeySize {
+			return nil
+		}
+		return ed25519.PublicKey(buffer)
+	}
+	return nil
+}
+
+func oidc_verify_signature(alg string, key any, data []byte, signature []byte) bool {
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		public_key, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		var hash, digest = oidc_digest(alg, data)
+		return rsa.VerifyPKCS1v15(public_key, hash, digest, signature) == nil
+	case "PS256", "PS384", "PS512":
+		public_key, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		var hash, digest = oidc_digest(alg, data)
+		return rsa.VerifyPSS(public_key, hash, digest, signature, nil) == nil
+	case "ES256", "ES384":
+		public_key, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		var size = (public_key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != size*2 {
+			return false
+		}
+		var _, digest = oidc_digest(alg, data)
+		var r, s = new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(public_key, digest, r, s)
+	case "EdDSA":
+		public_key, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return false
+		}
+		return ed25519.Verify(public_key, data, signature)
+	}
+	return false
+}
+
+func oidc_digest(alg string, data []byte) (crypto.Hash, []byte) {
+	switch alg[2:] {
+	case "384":
+		var sum = sha512.Sum384(data)
+		return crypto.SHA384, sum[:]
+	case "512":
+		var sum = sha512.Sum512(data)
+		return crypto.SHA512, sum[:]
+	}
+	var sum = sha256.Sum256(data)
+	return crypto.SHA256, sum[:]
+}
+
+// Signature (jwks of the provider), issuer, audience, expiry and nonce of the ID token
+func OIDC_VerifyIDToken(text string, nonce string) (*OIDCClaims, error) {
+	var values = strings.Split(text, ".")
+	if len(values) != 3 {
+		return nil, ErrorOIDCToken
+	}
+
+	header_data, err := base64.RawURLEncoding.DecodeString(values[0])
+	if err != nil {
+		return nil, ErrorOIDCToken
+	}
+	var header map[string]string
+	if json.Unmarshal(header_data, &header) != nil || len(header["alg"]) == 0 || header["alg"] == "none" {
+		return nil, ErrorOIDCToken
+	}
+
+	_, keys, err := oidc_provider_load(false)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[header["kid"]]
+	if !ok {
+		// Rotated by the provider
+		if _, keys, err = oidc_provider_load(true); err != nil {
+			return nil, err
+		}
+		if key, ok = keys[header["kid"]]; !ok {
+			return nil, ErrorOIDCToken
+		}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(values[2])
+	if err != nil || !oidc_verify_signature(header["alg"], key, []byte(values[0]+"."+values[1]), signature) {
+		return nil, ErrorOIDCToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(values[1])
+	if err != nil {
+		return nil, ErrorOIDCToken
+	}
+	var claims OIDCClaims
+	if json.Unmarshal(payload, &claims) != nil || len(claims.Subject) == 0 {
+		return nil, ErrorOIDCToken
+	}
+
+	var now = time.Now().Unix()
+	if strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(server_config.OIDC.Issuer, "/") ||
+		!claims.HasAudience(server_config.OIDC.ClientID) ||
+		now >= claims.ExpiresAt+OIDC_CLOCK_SKEW || claims.IssuedAt > now+OIDC_CLOCK_SKEW ||
+		len(nonce) == 0 || claims.Nonce != nonce {
+		return nil, ErrorOIDCToken
+	}
+	return &claims, nil
+}
+
+func (I *OIDCClaims) HasAudience(client_id string) bool {
+	switch value := I.Audience.(type) {
+	case string:
+		return value == client_id
+	case []any:
+		for _, v := range value {
+			if v == client_id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (I *OIDCClaims) IsEmailVerified() bool {
+	switch value := I.EmailVerified.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+func db_oidc_state_id(state string) string {
+	return fmt.Sprintf("oidc_state_%s", state)
+}
+
+// Authorization url of the provider (code, PKCE S256) and the state, the state is kept OIDC_STATE_TTL
+func OIDC_AuthorizationUrl(device_uid string) (string, string, error) {
+	discovery, _, err := oidc_provider_load(false)
+	if err != nil {
+		return "", "", err
+	}
+
+	var state = &DBOIDCState{
+		State:        strings.ToLower(utils.GenerateToken()[0:32]),
+		Nonce:        strings.ToLower(utils.GenerateToken()[0:32]),
+		CodeVerifier: strings.ToLower(utils.GenerateToken()),
+		DeviceUID:    device_uid,
+		CreateTime:   utils.DateFormat(time.Now(), 3),
+	}
+	if !database_redis.PushJson[DBOIDCState](db_oidc_state_id(state.State), state, OIDC_STATE_TTL, false) {
+		return "", "", ErrorLoginError
+	}
+
+	var challenge = sha256.Sum256([]byte(state.CodeVerifier))
+	var values = url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", server_config.OIDC.ClientID)
+	values.Set("redirect_uri", server_config.OIDC.RedirectUrl)
+	values.Set("scope", oidc_scopes())
+	values.Set("state", state.State)
+	values.Set("nonce", state.Nonce)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	var separator = "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + values.Encode(), state.State, nil
+}
+
+// State of the callback, used once
+func db_oidc_state_take(state string) *DBOIDCState {
+	state = strings.TrimSpace(state)
+	if !utils.CheckToken(state) {
+		return nil
+	}
+	var data DBOIDCState
+	if !database_redis.GetJson(db_oidc_state_id(state), &data, false) {
+		return nil
+	}
+	if !database_redis.DelWithKey(db_oidc_state_id(state)) || data.State != state {
+		return nil
+	}
+	return &data
+}
+
+// Authorization code of the callback, the ID token is verified
+func OIDC_Exchange(code string, state *DBOIDCState) (*OIDCClaims, error) {
+	discovery, _, err := oidc_provider_load(false)
+	if err != nil {
+		return nil, err
+	}
+
+	var values = url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", server_config.OIDC.RedirectUrl)
+	values.Set("client_id", server_config.OIDC.ClientID)
+	values.Set("code_verifier", state.CodeVerifier)
+
+	request, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if len(server_config.OIDC.ClientSecret) > 0 {
+		request.SetBasicAuth(url.QueryEscape(server_config.OIDC.ClientID), url.QueryEscape(server_config.OIDC.ClientSecret))
+	}
+
+	var client = http.Client{
+		Timeout: OIDC_TIMEOUT * time.Second,
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	buffer, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("oidc token request failed: " + response.Status)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(buffer, &token); err != nil || len(token.IDToken) == 0 {
+		return nil, ErrorOIDCToken
+	}
+	return OIDC_VerifyIDToken(token.IDToken, state.Nonce)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Local provider of the tests (discovery and jwks), the keys can be rotated
+type oidc_test_provider struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	keys   []OIDCJWK
+	// Issuer of the discovery document (default: the server url)
+	issuer string
+}
+
+func new_oidc_test_provider(t *testing.T) *oidc_test_provider {
+	var provider = &oidc_test_provider{}
+	var mux = http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		var issuer = provider.issuer
+		if len(issuer) == 0 {
+			issuer = provider.server.URL
+		}
+		json.NewEncoder(w).Encode(OIDCDiscovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JWKSUri:               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		provider.lock.Lock()
+		defer provider.lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"keys": provider.keys})
+	})
+	provider.server = httptest.NewServer(mux)
+
+	var config = server_config.OIDC
+	server_config.OIDC = OIDCConfig{Issuer: provider.server.URL, ClientID: "gpt-server"}
+	oidc_cache = &oidc_provider{}
+	t.Cleanup(func() {
+		provider.server.Close()
+		server_config.OIDC = config
+		oidc_cache = &oidc_provider{}
+	})
+	return provider
+}
+
+func (I *oidc_test_provider) add_key(key OIDCJWK) {
+	I.lock.Lock()
+	defer I.lock.Unlock()
+	I.keys = append(I.keys, key)
+}
+
+func oidc_test_base64(buffer []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
+
+func oidc_test_token(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	var data = oidc_test_base64(header) + "." + oidc_test_base64(payload)
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		var sum = sha256.Sum256([]byte(data))
+		signature, err = key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case "ES256":
+		var sum = sha256.Sum256([]byte(data))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), sum[:])
+		if err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[0:32])
+			s.FillBytes(signature[32:])
+		}
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data + "." + oidc_test_base64(signature)
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	var provider = new_oidc_test_provider(t)
+
+	rsa_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ec_key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed_key, _ := ed25519.GenerateKey(rand.Reader)
+	provider.add_key(OIDCJWK{Kty: "RSA", Kid: "rsa", Use: "sig",
+		N: oidc_test_base64(rsa_key.N.Bytes()), E: oidc_test_base64(big.NewInt(int64(rsa_key.E)).Bytes())})
+	provider.add_key(OIDCJWK{Kty: "EC", Kid: "ec", Crv: "P-256",
+		X: oidc_test_base64(ec_key.X.FillBytes(make([]byte, 32))), Y: oidc_test_base64(ec_key.Y.FillBytes(make([]byte, 32)))})
+	provider.add_key(OIDCJWK{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: oidc_test_base64(ed_key.Public().(ed25519.PublicKey))})
+	// Encryption keys are not used
+	provider.add_key(OIDCJWK{Kty: "RSA", Kid: "enc", Use: "enc",
+		N: oidc_test_base64(rsa_key.N.Bytes()), E: oidc_test_base64(big.NewInt(int64(rsa_key.E)).Bytes())})
+
+	var now = time.Now().Unix()
+	var claims = func(changes map[string]any) map[string]any {
+		var values = map[string]any{
+			"iss":   provider.server.URL,
+			"sub":   "user-1",
+			"aud":   "gpt-server",
+			"exp":   now + 300,
+			"iat":   now,
+			"nonce": "nonce-1",
+			"email": "user@example.com",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(values, k)
+			} else {
+				values[k] = v
+			}
+		}
+		return values
+	}
+
+	var tests = []struct {
+		name  string
+		token string
+		nonce string
+		ok    bool
+	}{
+		{"RS256", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(nil)), "nonce-1", true},
+		{"ES256", oidc_test_token(t, "ES256", "ec", ec_key, claims(nil)), "nonce-1", true},
+		{"EdDSA", oidc_test_token(t, "EdDSA", "ed", ed_key, claims(nil)), "nonce-1", true},
+		{"audience list", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"aud": []string{"other", "gpt-server"}})), "nonce-1", true},
+		{"issuer slash", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"iss": provider.server.URL + "/"})), "nonce-1", true},
+		{"clock skew", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"exp": now - 10})), "nonce-1", true},
+		{"nonce", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(nil)), "nonce-2", false},
+		{"empty nonce", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"nonce": ""})), "", false},
+		{"audience", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"aud": "other"})), "nonce-1", false},
+		{"issuer", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"iss": "https://other.example.com"})), "nonce-1", false},
+		{"expired", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"exp": now - OIDC_CLOCK_SKEW - 1})), "nonce-1", false},
+		{"issued in the future", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"iat": now + OIDC_CLOCK_SKEW + 60})), "nonce-1", false},
+		{"subject", oidc_test_token(t, "RS256", "rsa", rsa_key, claims(map[string]any{"sub": nil})), "nonce-1", false},
+		{"key of another alg", oidc_test_token(t, "ES256", "rsa", ec_key, claims(nil)), "nonce-1", false},
+		{"encryption key", oidc_test_token(t, "RS256", "enc", rsa_key, claims(nil)), "nonce-1", false},
+		{"unknown kid", oidc_test_token(t, "RS256", "unknown", rsa_key, claims(nil)), "nonce-1", false},
+		{"alg none", strings.Join(strings.Split(oidc_test_token(t, "RS256", "rsa", rsa_key, claims(nil)), ".")[0:2], ".") + ".", "nonce-1", false},
+		{"format", "a.b", "nonce-1", false},
+	}
+
+	// Tampered payload
+	var values = strings.Split(oidc_test_token(t, "RS256", "rsa", rsa_key, claims(nil)), ".")
+	payload, _ := json.Marshal(claims(map[string]any{"sub": "admin"}))
+	values[1] = oidc_test_base64(payload)
+	tests = append(tests, struct {
+		name  string
+		token string
+		nonce string
+		ok    bool
+	}{"tampered", strings.Join(values, "."), "nonce-1", false})
+
+	for _, v := range tests {
+		claims, err := OIDC_VerifyIDToken(v.token, v.nonce)
+		if v.ok && (err != nil || claims.Subject != "user-1" || claims.Email != "user@example.com") {
+			t.Errorf("%s: OIDC_VerifyIDToken() = %+v, %v", v.name, claims, err)
+		}
+		if !v.ok && err == nil {
+			t.Errorf("%s: OIDC_VerifyIDToken() succeeded", v.name)
+		}
+	}
+}
+
+// The keys are loaded again for an unknown kid (once a minute)
+func TestOIDCKeyRotation(t *testing.T) {
+	var provider = new_oidc_test_provider(t)
+
+	old_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider.add_key(OIDCJWK{Kty: "RSA", Kid: "old",
+		N: oidc_test_base64(old_key.N.Bytes()), E: oidc_test_base64(big.NewInt(int64(old_key.E)).Bytes())})
+	var claims = map[string]any{"iss": provider.server.URL, "sub": "user-1", "aud": "gpt-server",
+		"exp": time.Now().Unix() + 300, "nonce": "n"}
+	if _, err := OIDC_VerifyIDToken(oidc_test_token(t, "RS256", "old", old_key, claims), "n"); err != nil {
+		t.Fatalf("OIDC_VerifyIDToken(old) = %v", err)
+	}
+
+	_, ed_key, _ := ed25519.GenerateKey(rand.Reader)
+	provider.add_key(OIDCJWK{Kty: "OKP", Kid: "new", Crv: "Ed25519", X: oidc_test_base64(ed_key.Public().(ed25519.PublicKey))})
+	var token = oidc_test_token(t, "EdDSA", "new", ed_key, claims)
+	if _, err := OIDC_VerifyIDToken(token, "n"); err == nil {
+		t.Errorf("OIDC_VerifyIDToken(new) succeeded before the reload delay")
+	}
+
+	oidc_cache.keys_loaded = time.Now().Add(-time.Minute)
+	if _, err := OIDC_VerifyIDToken(token, "n"); err != nil {
+		t.Errorf("OIDC_VerifyIDToken(new) = %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuer(t *testing.T) {
+	var provider = new_oidc_test_provider(t)
+	provider.issuer = "https://other.example.com"
+	if _, _, err := oidc_provider_load(false); err == nil {
+		t.Errorf("oidc_provider_load() of another issuer succeeded")
+	}
+}
+
+// The state of the callback is bound to the browser of the login (login CSRF)
+func TestOIDCStateCookie(t *testing.T) {
+	var login = httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(login)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/server/oidc/login", nil)
+	oidc_state_cookie_set(ctx, "state-1")
+
+	var cookies = login.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OIDC_STATE_COOKIE || !cookies[0].HttpOnly ||
+		cookies[0].Path != OIDC_STATE_COOKIE_PATH || cookies[0].SameSite != http.SameSiteLaxMode ||
+		strings.Contains(cookies[0].Value, "state-1") {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+
+	var tests = []struct {
+		name   string
+		cookie *http.Cookie
+		state  string
+		ok     bool
+	}{
+		{"same browser", cookies[0], "state-1", true},
+		{"another state", cookies[0], "state-2", false},
+		{"no cookie", nil, "state-1", false},
+		{"empty state", &http.Cookie{Name: OIDC_STATE_COOKIE, Value: ""}, "", false},
+	}
+	for _, v := range tests {
+		var recorder = httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/server/oidc/callback?state="+v.state, nil)
+		if v.cookie != nil {
+			ctx.Request.AddCookie(v.cookie)
+		}
+		if ok := oidc_state_cookie_check(ctx, v.state); ok != v.ok {
+			t.Errorf("%s: oidc_state_cookie_check() = %v, want %v", v.name, ok, v.ok)
+		}
+		// The cookie is removed
+		if cookies := recorder.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+			t.Errorf("%s: the cookie is not removed (%+v)", v.name, cookies)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package server
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
-	
+
 	"mcmcx.com/gpt-server/httpx"
 	"mcmcx.com/gpt-server/utils"
 )
@@ -45,7 +44,7 @@ func (I *OPEMAI_MODELS) Find(id string) *OPENAI_MODEL_ITEM {
 	var count = len(I.Data)
 	for i := 0; i < count; i++ {
 		var v = I.Data[i]
-		if(v.ID == id) {
+		if v.ID == id {
 			item = &v
 			break
 		}
@@ -53,27 +52,57 @@ func (I *OPEMAI_MODELS) Find(id string) *OPENAI_MODEL_ITEM {
 	return item
 }
 
-var aiapi_client *httpx.HTTPClient2 = nil
-
 func API_GPTInit(config Config) bool {
 
-	var additional_headers map[string]string = map[string]string{}
-	additional_headers["Openai-Organization"] = config.APIOrganization
-	additional_headers["Authorization"] = fmt.Sprintf("Bearer %s", config.APIKey)
+	if !OpenAI_UpstreamInit(config) {
+		return false
+	}
+
+	if !OpenAI_PolicyInit(config.ModelPolicies) {
+		return false
+	}
+
+	if !OpenAI_FallbackInit(config.ModelFallbacks) {
+		return false
+	}
+
+	if !OpenAI_TokenizerInit(config.TokenizerFile) {
+		return false
+	}
+
+	if !OpenAI_PriceInit(config.ModelPrices) {
+		return false
+	}
+
+	if !UserPlan_Init(config.Plans, config.DefaultPlan) {
+		return false
+	}
 
-	aiapi_client = httpx.NewClient(config.APIUrl, additional_headers)
-	if aiapi_client == nil {
+	if !Prompt_Init(config) {
 		return false
 	}
 
 	return true
 }
 
+// Model id of the JSON payload
+func api_payload_model(payload any) string {
+	body, ok := payload.(map[string]any)
+	if !ok {
+		return ""
+	}
+	model_id, ok := body["model"].(string)
+	if !ok {
+		return ""
+	}
+	return model_id
+}
+
 // OpenAI API : Models
 // curl https://api.openai.com/v1/models \
 // -H "Authorization: Bearer $OPENAI_API_KEY" \
 // -H "OpenAI-Organization: YOUR_ORG_ID"
-func API_GPTModels2() *httpx.HTTPData2 {
+func API_GPTModels2(upstream *OpenAIUpstream) *httpx.HTTPData2 {
 
 	//var models []ChatGPTModel
 
@@ -84,17 +113,106 @@ func API_GPTModels2() *httpx.HTTPData2 {
 	}
 	//data.Get(&models)
 
-	aiapi_client.HTTPRequest2("/v1/models", nil, &data)
+	upstream.HTTPRequest2("models", "", &data)
 	return &data
 }
 
-func API_GPTCompletions2(payload any, ondata func(int, *[]byte, int, *httpx.HTTPData2)) *httpx.HTTPData2 {
+// OpenAI API : Chat Completions
+// ondata == nil : buffered mode, the whole chat.completion object is read by HTTPReadable2
+// ondata != nil : stream mode, every SSE line is passed to ondata
+func API_GPTCompletions2(upstream *OpenAIUpstream, payload any, ondata func(int, *[]byte, int, *httpx.HTTPData2)) *httpx.HTTPData2 {
 	data := httpx.HTTPData2{
 		Method:     http.MethodPost,
 		SkipVerify: true,
 		//Headers:    http_additional_headers,
 		Payload: payload,
 		//
+		HasStream: ondata != nil,
+	}
+	if ondata != nil {
+		data.CallbackStream = func(index int, buffer *[]byte, length int, sender *httpx.HTTPData2) {
+			ondata(index, buffer, length, sender)
+		}
+	}
+
+	upstream.HTTPRequest2("chat/completions", api_payload_model(payload), &data)
+
+	utils.Logger.Log("(API) Request GPTCompletions (Time: ", data.EndTime(), "ms)")
+	return &data
+}
+
+// OpenAI API : Embeddings
+// curl https://api.openai.com/v1/embeddings \
+// -H "Authorization: Bearer $OPENAI_API_KEY" \
+// -d '{"input": "The food was delicious", "model": "text-embedding-ada-002", "encoding_format": "float"}'
+func API_GPTEmbeddings2(upstream *OpenAIUpstream, payload any) *httpx.HTTPData2 {
+	data := httpx.HTTPData2{
+		Method:     http.MethodPost,
+		SkipVerify: true,
+		Payload:    payload,
+	}
+
+	upstream.HTTPRequest2("embeddings", api_payload_model(payload), &data)
+
+	utils.Logger.Log("(API) Request GPTEmbeddings (Time: ", data.EndTime(), "ms)")
+	return &data
+}
+
+// OpenAI API : Images
+// curl https://api.openai.com/v1/images/generations \
+// -H "Authorization: Bearer $OPENAI_API_KEY" \
+// -d '{"model": "dall-e-3", "prompt": "a white siamese cat", "n": 1, "size": "1024x1024"}'
+func API_GPTImages2(upstream *OpenAIUpstream, payload any) *httpx.HTTPData2 {
+	data := httpx.HTTPData2{
+		Method:     http.MethodPost,
+		SkipVerify: true,
+		Payload:    payload,
+	}
+
+	upstream.HTTPRequest2("images/generations", api_payload_model(payload), &data)
+
+	utils.Logger.Log("(API) Request GPTImages (Time: ", data.EndTime(), "ms)")
+	return &data
+}
+
+// OpenAI API : Audio transcriptions
+// curl https://api.openai.com/v1/audio/transcriptions \
+// -H "Authorization: Bearer $OPENAI_API_KEY" \
+// -H "Content-Type: multipart/form-data" \
+// -F file="@/path/to/file/audio.mp3" \
+// -F model="whisper-1"
+func API_GPTTranscriptions2(upstream *OpenAIUpstream, model_id string, payload []byte, content_type string) *httpx.HTTPData2 {
+	data := httpx.HTTPData2{
+		Method:     http.MethodPost,
+		SkipVerify: true,
+		Headers: map[string]string{
+			"Content-Type": content_type,
+		},
+		Payload: payload,
+		// response_format : text, srt, vtt
+		AllowText: true,
+	}
+
+	upstream.HTTPRequest2("audio/transcriptions", model_id, &data)
+
+	utils.Logger.Log("(API) Request GPTTranscriptions (Time: ", data.EndTime(), "ms)")
+	return &data
+}
+
+// OpenAI API : Audio speech (binary audio stream)
+// curl https://api.openai.com/v1/audio/speech \
+// -H "Authorization: Bearer $OPENAI_API_KEY" \
+// -d '{"model": "tts-1", "input": "Today is a wonderful day!", "voice": "alloy"}' \
+// --output speech.mp3
+func API_GPTSpeech2(upstream *OpenAIUpstream, payload any, ondata func(int, *[]byte, int, *httpx.HTTPData2)) *httpx.HTTPData2 {
+	data := httpx.HTTPData2{
+		Method:     http.MethodPost,
+		SkipVerify: true,
+		Headers: map[string]string{
+			"Accept": "*/*",
+		},
+		Payload: payload,
+		//
 		HasStream: true,
 		CallbackStream: func(index int, buffer *[]byte, length int, sender *httpx.HTTPData2) {
 			if ondata != nil {
@@ -103,8 +221,8 @@ func API_GPTCompletions2(payload any, ondata func(int, *[]byte, int, *httpx.HTTP
 		},
 	}
 
-	aiapi_client.HTTPRequest2("/v1/chat/completions", nil, &data)
+	upstream.HTTPRequest2("audio/speech", api_payload_model(payload), &data)
 
-	utils.Logger.Log("(API) Request GPTCompletions (Time: ", data.EndTime(), "ms)")
+	utils.Logger.Log("(API) Request GPTSpeech (Time: ", data.EndTime(), "ms)")
 	return &data
 }
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Response header : HIT or MISS (cacheable requests only)
+	OPENAI_CACHE_HEADER = "X-Cache"
+	OPENAI_CACHE_HIT    = "HIT"
+	OPENAI_CACHE_MISS   = "MISS"
+	// Max size of the cached response (bytes)
+	OPENAI_CACHE_MAX = 1 << 20
+)
+
+// Cached response of the deterministic chat request (completion_cache_<sha256 of the request>)
+type DBCompletionCache struct {
+	// Model actually used
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+	// chat.completion object (non-streaming) or SSE bytes (streaming)
+	Result     map[string]any `json:"result,omitempty"`
+	Events     string         `json:"events,omitempty"`
+	CreateTime string         `json:"create_time"`
+}
+
+// Response cache of one chat request, nil : not cacheable
+type OpenAICache struct {
+	Key    string
+	Model  string
+	Stream bool
+	//
+	events bytes.Buffer
+}
+
+func db_completion_cache_id(hash string) string {
+	return fmt.Sprintf("completion_cache_%s", hash)
+}
+
+// Seconds, 0 : disabled
+func OpenAI_CacheEnabled() bool {
+	return server_config.CompletionCacheTTL > 0
+}
+
+// temperature 0 of the client body (before the policy defaults)
+func OpenAI_CacheDeterministic(body map[string]any) bool {
+	number, ok := to_number(body["temperature"])
+	return ok && number == 0
+}
+
+// Cache-Control : no-cache or no-store, the cache is not used
+func openai_cache_bypass(ctx *gin.Context) bool {
+	var value = strings.ToLower(ctx.GetHeader("Cache-Control"))
+	return strings.Contains(value, "no-cache") || strings.Contains(value, "no-store")
+}
+
+// Cache of the prepared chat request (model, messages and parameters) of the user (IDX),
+// the responses are not shared between the users, the end-user id of the body is not a part of the key
+// deterministic : temperature 0 of the client body, the prepared body must keep it (the policy may clamp it)
+func NewOpenAICache(ctx *gin.Context, body map[string]any, deterministic bool, idx utils.TIDX) *OpenAICache {
+	if !OpenAI_CacheEnabled() || !deterministic || !OpenAI_CacheDeterministic(body) || openai_cache_bypass(ctx) {
+		return nil
+	}
+
+	var payload = map[string]any{}
+	for k, v := range body {
+		payload[k] = v
+	}
+	delete(payload, "user")
+
+	// The map keys are sorted
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	stream, _ := body["stream"].(bool)
+	model_id, _ := body["model"].(string)
+	return &OpenAICache{
+		Key:    db_completion_cache_id(strings.ToLower(utils.SHA256(fmt.Sprintf("%d|%s", idx, bytes)))),
+		Model:  model_id,
+		Stream: stream,
+	}
+}
+
+// The cached response is written (SSE events at full speed, or the JSON object),
+// the reply content is accumulated for the conversation, the usage is not saved
+func (I *OpenAICache) Replay(ctx *gin.Context, usage *OpenAIUsage) bool {
+	if I == nil {
+		return false
+	}
+
+	var data DBCompletionCache
+	if !database_redis.GetJson(I.Key, &data, false) || data.Stream != I.Stream {
+		ctx.Header(OPENAI_CACHE_HEADER, OPENAI_CACHE_MISS)
+		return false
+	}
+
+	usage.Model = data.Model
+	ctx.Header(OPENAI_CACHE_HEADER, OPENAI_CACHE_HIT)
+	ctx.Header(OPENAI_MODEL_HEADER, data.Model)
+	if !data.Stream {
+		usage.ParseResult(data.Result)
+		usage.Finish()
+		ctx.JSON(http.StatusOK, data.Result)
+		return true
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+	usage.ParseStream([]byte(data.Events))
+	usage.Finish()
+	ctx.Writer.Write([]byte(data.Events))
+	ctx.Writer.Flush()
+	return true
+}
+
+// SSE bytes of the streaming response
+func (I *OpenAICache) Record(buffer []byte) {
+	if I == nil || I.events.Len() > OPENAI_CACHE_MAX {
+		return
+	}
+	I.events.Write(buffer)
+}
+
+// The responses of the fallback models and the incomplete streams are not cached
+func (I *OpenAICache) Save(model_id string, result map[string]any) bool {
+	if I == nil || model_id != I.Model {
+		return false
+	}
+
+	var data = DBCompletionCache{
+		Model:      model_id,
+		Stream:     I.Stream,
+		CreateTime: utils.DateFormat(time.Now(), 3),
+	}
+	if I.Stream {
+		if I.events.Len() > OPENAI_CACHE_MAX || !bytes.Contains(I.events.Bytes(), []byte("[DONE]")) {
+			return false
+		}
+		data.Events = I.events.String()
+	} else {
+		if result == nil {
+			return false
+		}
+		data.Result = result
+	}
+
+	if !database_redis.PushJson[DBCompletionCache](I.Key, &data, float32(server_config.CompletionCacheTTL), false) {
+		utils.Logger.LogWarning("[AI] Completions cache (Model:", model_id, ") saving failure.")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func cache_test_context(cache_control string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	if len(cache_control) > 0 {
+		ctx.Request.Header.Set("Cache-Control", cache_control)
+	}
+	return ctx
+}
+
+func cache_test_body(user string, content string, temperature any) map[string]any {
+	return map[string]any{
+		"model":       "gpt-4",
+		"user":        user,
+		"stream":      true,
+		"temperature": temperature,
+		"messages":    []any{map[string]any{"role": "user", "content": content}},
+	}
+}
+
+func TestCacheDeterministic(t *testing.T) {
+	var tests = []struct {
+		temperature any
+		ok          bool
+	}{
+		{float64(0), true},
+		{0, true},
+		{0.2, false},
+		{"0", false},
+		{nil, false},
+	}
+	for _, v := range tests {
+		if ok := OpenAI_CacheDeterministic(map[string]any{"temperature": v.temperature}); ok != v.ok {
+			t.Errorf("OpenAI_CacheDeterministic(%#v) = %v, want %v", v.temperature, ok, v.ok)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	var ttl = server_config.CompletionCacheTTL
+	server_config.CompletionCacheTTL = 60
+	defer func() {
+		server_config.CompletionCacheTTL = ttl
+	}()
+
+	var cache = NewOpenAICache(cache_test_context(""), cache_test_body("a", "hello", float64(0)), true, 1)
+	if cache == nil || cache.Model != "gpt-4" || !cache.Stream {
+		t.Fatalf("NewOpenAICache() = %+v", cache)
+	}
+
+	// The end-user id of the body is not a part of the key
+	if other := NewOpenAICache(cache_test_context(""), cache_test_body("b", "hello", float64(0)), true, 1); other == nil || other.Key != cache.Key {
+		t.Errorf("NewOpenAICache(other user) = %+v, want the key %s", other, cache.Key)
+	}
+	if other := NewOpenAICache(cache_test_context(""), cache_test_body("a", "hello!", float64(0)), true, 1); other == nil || other.Key == cache.Key {
+		t.Errorf("NewOpenAICache(other messages) = %+v, want another key", other)
+	}
+	// The responses are not shared between the users (IDX)
+	if other := NewOpenAICache(cache_test_context(""), cache_test_body("a", "hello", float64(0)), true, 2); other == nil || other.Key == cache.Key {
+		t.Errorf("NewOpenAICache(other IDX) = %+v, want another key", other)
+	}
+
+	var tests = []struct {
+		name          string
+		cache_control string
+		deterministic bool
+		body          map[string]any
+	}{
+		{"not deterministic", "", false, cache_test_body("a", "hello", float64(0))},
+		{"no-cache", "no-cache", true, cache_test_body("a", "hello", float64(0))},
+		{"no-store", "private, no-store", true, cache_test_body("a", "hello", float64(0))},
+		// temperature 0 of the client, raised by the policy
+		{"clamped temperature", "", true, cache_test_body("a", "hello", 0.5)},
+	}
+	for _, v := range tests {
+		if cache := NewOpenAICache(cache_test_context(v.cache_control), v.body, v.deterministic, 1); cache != nil {
+			t.Errorf("%s: NewOpenAICache() = %+v, want nil", v.name, cache)
+		}
+	}
+
+	server_config.CompletionCacheTTL = 0
+	if cache := NewOpenAICache(cache_test_context(""), cache_test_body("a", "hello", float64(0)), true, 1); cache != nil {
+		t.Errorf("NewOpenAICache(disabled) = %+v, want nil", cache)
+	}
+}
+
+// The policy clamps the temperature of the client before the cache is created
+func TestCachePolicyClamp(t *testing.T) {
+	OpenAI_PolicyInit([]OpenAIModelPolicy{{Model: "gpt-4", Temperature: []float64{0.5, 1}}})
+	defer OpenAI_PolicyInit(nil)
+
+	var body = cache_test_body("a", "hello", float64(0))
+	var deterministic = OpenAI_CacheDeterministic(body)
+	if err := OpenAI_Policy("gpt-4").Apply(body); err != nil {
+		t.Fatal(err.Message)
+	}
+	if !deterministic || OpenAI_CacheDeterministic(body) {
+		t.Errorf("temperature = %v, the prepared request is deterministic", body["temperature"])
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Context window strategies (openai_model_policies : context)
+	OPENAI_CONTEXT_REJECT    = "reject"
+	OPENAI_CONTEXT_DROP      = "drop_oldest"
+	OPENAI_CONTEXT_KEEP_LAST = "keep_last"
+	OPENAI_CONTEXT_SUMMARIZE = "summarize"
+	//
+	OPENAI_CONTEXT_KEEP_LAST_DEFAULT = 10
+	OPENAI_CONTEXT_SUMMARY_MODEL     = "gpt-3.5-turbo"
+	OPENAI_CONTEXT_SUMMARY_TOKENS    = 512
+	// Summaries of the same messages are reused (seconds)
+	OPENAI_CONTEXT_SUMMARY_KEEP = 86400
+	// Response header, count of the messages removed from the request
+	OPENAI_CONTEXT_HEADER = "X-Context-Trimmed"
+)
+
+const openai_context_summary_prompt = "Summarize the following conversation in a few sentences. " +
+	"Keep the facts, names, decisions and open questions that are needed to continue the conversation."
+
+// Summary of the messages (context_summary_<sha256 of the messages>)
+func db_context_summary_id(model_id string, messages []any) string {
+	bytes, _ := json.Marshal(messages)
+	return fmt.Sprintf("context_summary_%s", strings.ToLower(utils.SHA256(model_id + "|" + string(bytes)))[0:32])
+}
+
+func openai_context_strategy(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case OPENAI_CONTEXT_DROP, OPENAI_CONTEXT_KEEP_LAST, OPENAI_CONTEXT_SUMMARIZE:
+		return value
+	}
+	return OPENAI_CONTEXT_REJECT
+}
+
+func openai_message_role(value any) string {
+	message, ok := value.(map[string]any)
+	if !ok {
+		return ""
+	}
+	role, _ := message["role"].(string)
+	return role
+}
+
+// Prompt tokens budget, the completion tokens are reserved (max_tokens, at most the half of the context window)
+func (I *OpenAIModelPolicy) context_budget(body map[string]any) int {
+	var reserve = I.DefaultMaxTokens
+	if number, ok := to_number(body["max_tokens"]); ok && number >= 1 {
+		reserve = int(number)
+	}
+	if reserve > I.ContextTokens/2 {
+		reserve = I.ContextTokens / 2
+	}
+	return I.ContextTokens - reserve
+}
+
+// The older messages as one text (role: content), the oldest are removed over the tokens limit
+func openai_context_transcript(messages []any, limit int) string {
+	var lines = []string{}
+	for _, v := range messages {
+		message, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		text, _ := openai_message_content(message["content"])
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", openai_message_role(message), text))
+	}
+	for len(lines) > 1 && OpenAI_CountText(strings.Join(lines, "\n")) > limit {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Summary of the older messages by the summary model (non-streaming), empty : failure
+// The tokens are accounted to the user
+func openai_context_summary(policy *OpenAIModelPolicy, messages []any, idx utils.TIDX) string {
+	var model_id = policy.SummaryModel
+	var key = db_context_summary_id(model_id, messages)
+	if text, ok := database_redis.GetString(key); ok && len(text) > 0 {
+		return text
+	}
+
+	var upstream = OpenAI_Upstream(model_id)
+	if upstream == nil {
+		utils.Logger.LogWarning("[AI] Context summary (Model:", model_id, ") not found upstream.")
+		return ""
+	}
+
+	var summary_policy = OpenAI_Policy(model_id)
+	var limit = summary_policy.ContextTokens - OPENAI_CONTEXT_SUMMARY_TOKENS - OpenAI_CountText(openai_context_summary_prompt) - 64
+	var payload_messages = []any{
+		map[string]any{"role": "system", "content": openai_context_summary_prompt},
+		map[string]any{"role": "user", "content": openai_context_transcript(messages, limit)},
+	}
+	var payload = map[string]any{
+		"model":       model_id,
+		"messages":    payload_messages,
+		"max_tokens":  OPENAI_CONTEXT_SUMMARY_TOKENS,
+		"temperature": 0,
+		"stream":      false,
+	}
+
+	data := API_GPTCompletions2(upstream, payload, nil)
+	if data.ErrorCode != httpx.HTTP_RESULT_OK {
+		utils.Logger.LogWarning("[AI] Context summary (Model:", model_id, ") failed (", data.ErrorCode, ", ", data.ErrorMessage, ")")
+		return ""
+	}
+	result, ok := data.Data().(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	var usage = NewOpenAIUsage(idx, model_id, OpenAI_CountMessages(payload_messages))
+	usage.ParseResult(result)
+	usage.Finish()
+	usage.Save()
+
+	var text = strings.TrimSpace(usage.Content())
+	if len(text) > 0 {
+		database_redis.PushString(key, text, OPENAI_CONTEXT_SUMMARY_KEEP)
+	}
+	return text
+}
+
+// Messages of the chat request over the context window are trimmed by the policy strategy (context)
+// The leading system messages and the last message are kept, return the count of the removed messages
+// reject : the request is not changed, ApplyTokens rejects it (context_length_exceeded)
+func OpenAI_TrimContext(body map[string]any, model_id string, idx utils.TIDX) int {
+	var policy = OpenAI_Policy(model_id)
+	if policy.Context == OPENAI_CONTEXT_REJECT {
+		return 0
+	}
+	messages, ok := body["messages"].([]any)
+	if !ok || len(messages) < 2 {
+		return 0
+	}
+	var budget = policy.context_budget(body)
+	if OpenAI_CountMessages(messages) <= budget {
+		return 0
+	}
+
+	// Only the leading system messages are kept, the later ones are trimmed in place with the others
+	var leading = 0
+	for leading < len(messages) && openai_message_role(messages[leading]) == "system" {
+		leading++
+	}
+	var system = append([]any{}, messages[0:leading]...)
+	var others = append([]any{}, messages[leading:]...)
+	var total = len(others)
+
+	switch policy.Context {
+	case OPENAI_CONTEXT_KEEP_LAST:
+		if len(others) > policy.ContextKeepLast {
+			others = others[len(others)-policy.ContextKeepLast:]
+		}
+	case OPENAI_CONTEXT_SUMMARIZE:
+		// Falls back to drop_oldest when the summary is failed
+		if len(others) > policy.ContextKeepLast {
+			var older = others[0 : len(others)-policy.ContextKeepLast]
+			if text := openai_context_summary(policy, older, idx); len(text) > 0 {
+				system = append(system, map[string]any{
+					"role":    "system",
+					"content": "Summary of the earlier conversation:\n" + text,
+				})
+				others = others[len(others)-policy.ContextKeepLast:]
+			}
+		}
+	}
+
+	// The oldest turns are removed until the messages fit the budget (all strategies),
+	// tool results without the assistant tool calls are not valid
+	var count = func() int {
+		return OpenAI_CountMessages(system) + OpenAI_CountMessages(others)
+	}
+	for len(others) > 1 && count() > budget {
+		others = others[1:]
+	}
+	for len(others) > 1 {
+		var role = openai_message_role(others[0])
+		if role != "tool" && role != "function" {
+			break
+		}
+		others = others[1:]
+	}
+
+	var payload = make([]any, 0, len(system)+len(others))
+	payload = append(payload, system...)
+	payload = append(payload, others...)
+	body["messages"] = payload
+	return total - len(others)
+}
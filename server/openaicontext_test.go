@@ -0,0 +1,112 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// Without the tokenizer the tokens are estimated (tokenizer.Estimate, ASCII 4 bytes per token) :
+// system message 3 + 2 + 2 = 7 tokens, other messages 3 + 1 + 10 = 14 tokens
+func context_test_messages(roles ...string) []any {
+	var messages = []any{map[string]any{"role": "system", "content": strings.Repeat("s", 8)}}
+	for i, role := range roles {
+		var content = strings.Repeat(string(rune('a'+i)), 40)
+		messages = append(messages, map[string]any{"role": role, "content": content})
+	}
+	return messages
+}
+
+func context_test_contents(messages []any) string {
+	var text = ""
+	for _, v := range messages {
+		content, _ := v.(map[string]any)["content"].(string)
+		text += content[0:1]
+	}
+	return text
+}
+
+func TestContextStrategy(t *testing.T) {
+	var tests = map[string]string{
+		"":             OPENAI_CONTEXT_REJECT,
+		"unknown":      OPENAI_CONTEXT_REJECT,
+		" Drop_Oldest": OPENAI_CONTEXT_DROP,
+		"keep_last":    OPENAI_CONTEXT_KEEP_LAST,
+		"SUMMARIZE":    OPENAI_CONTEXT_SUMMARIZE,
+	}
+	for value, strategy := range tests {
+		if result := openai_context_strategy(value); result != strategy {
+			t.Errorf("openai_context_strategy(%q) = %q, want %q", value, result, strategy)
+		}
+	}
+}
+
+func TestContextBudget(t *testing.T) {
+	var policy = OpenAIModelPolicy{ContextTokens: 100, DefaultMaxTokens: 20}
+	var tests = []struct {
+		body   map[string]any
+		budget int
+	}{
+		{map[string]any{}, 80},
+		{map[string]any{"max_tokens": 10}, 90},
+		// At most the half of the context window is reserved
+		{map[string]any{"max_tokens": 70}, 50},
+	}
+	for _, v := range tests {
+		if budget := policy.context_budget(v.body); budget != v.budget {
+			t.Errorf("context_budget(%v) = %d, want %d", v.body, budget, v.budget)
+		}
+	}
+}
+
+func TestTrimContext(t *testing.T) {
+	var users = []string{"user", "user", "user", "user", "user", "user", "user", "user"}
+	var tests = []struct {
+		name      string
+		context   string
+		keep_last int
+		roles     []string
+		removed   int
+		contents  string
+	}{
+		{"reject", OPENAI_CONTEXT_REJECT, 0, users, 0, "sabcdefgh"},
+		{"under the budget", OPENAI_CONTEXT_DROP, 0, users[0:5], 0, "sabcde"},
+		// 7 + 14 * 5 <= 90
+		{"drop_oldest", OPENAI_CONTEXT_DROP, 0, users, 3, "sdefgh"},
+		{"keep_last", OPENAI_CONTEXT_KEEP_LAST, 3, users, 5, "sfgh"},
+		// The kept messages are still over the budget
+		{"keep_last over the budget", OPENAI_CONTEXT_KEEP_LAST, 7, users, 3, "sdefgh"},
+		// Not enough messages to summarize : drop_oldest
+		{"summarize", OPENAI_CONTEXT_SUMMARIZE, 8, users, 3, "sdefgh"},
+		// The tool result without the assistant tool call is removed
+		// The later system message is not moved to the front : 7 + 15 + 14 * 4 <= 90
+		{"interleaved system", OPENAI_CONTEXT_DROP, 0, []string{"user", "user", "user", "user", "system", "user", "user", "user"}, 3, "sdefgh"},
+		{"tool result", OPENAI_CONTEXT_DROP, 0, []string{"user", "user", "user", "tool", "user", "user", "user", "user"}, 4, "sefgh"},
+	}
+
+	defer OpenAI_PolicyInit(nil)
+	for _, v := range tests {
+		OpenAI_PolicyInit([]OpenAIModelPolicy{{Model: "test", ContextTokens: 100, Context: v.context, ContextKeepLast: v.keep_last}})
+		var body = map[string]any{"messages": context_test_messages(v.roles...), "max_tokens": 10}
+
+		var removed = OpenAI_TrimContext(body, "test", 0)
+		var messages = body["messages"].([]any)
+		if removed != v.removed || context_test_contents(messages) != v.contents {
+			t.Errorf("%s: OpenAI_TrimContext() = %d (%s), want %d (%s)", v.name, removed, context_test_contents(messages), v.removed, v.contents)
+		}
+	}
+}
+
+func TestContextTranscript(t *testing.T) {
+	var messages = []any{
+		map[string]any{"role": "user", "content": "hello"},
+		map[string]any{"role": "assistant", "content": ""},
+		map[string]any{"role": "assistant", "content": []any{map[string]any{"type": "text", "text": "hi"}}},
+	}
+	if text := openai_context_transcript(messages, 100); text != "user: hello\nassistant: hi" {
+		t.Errorf("openai_context_transcript() = %q", text)
+	}
+	// The oldest lines are removed over the limit
+	if text := openai_context_transcript(messages, 4); text != "assistant: hi" {
+		t.Errorf("openai_context_transcript(limit) = %q", text)
+	}
+}
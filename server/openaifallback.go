@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+// Model actually used (fallback chain)
+const OPENAI_MODEL_HEADER = "X-Model-Used"
+
+// Fallback chains (config.yaml : openai_model_fallbacks), a trailing '*' matches by prefix
+//
+//	openai_model_fallbacks:
+//	  gpt-4: ["gpt-4-turbo", "gpt-3.5-turbo"]
+//	  gpt-3.5-turbo*: ["gpt-3.5-turbo-16k"]
+var openai_fallbacks map[string][]string = map[string][]string{}
+
+func OpenAI_FallbackInit(fallbacks map[string][]string) bool {
+	openai_fallbacks = map[string][]string{}
+	for k, list := range fallbacks {
+		k = strings.ToLower(strings.TrimSpace(k))
+		var values = []string{}
+		for _, v := range list {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+		if len(k) > 0 {
+			openai_fallbacks[k] = values
+		}
+	}
+	return true
+}
+
+// Model and its fallbacks, only the models served by an upstream,
+// and allowed by the plan and the API key of the user (nil : no limits)
+func OpenAI_FallbackChain(model_id string, plan *UserPlan, api_key *DBAPIKeyData) []string {
+	var chain = []string{model_id}
+
+	// Exact id, or the longest prefix
+	var list, ok = openai_fallbacks[model_id]
+	if !ok {
+		var pattern = ""
+		for k, v := range openai_fallbacks {
+			if model_match(k, model_id) && len(k) > len(pattern) {
+				pattern = k
+				list = v
+			}
+		}
+	}
+
+	for _, v := range list {
+		if v == model_id || OPENAI_Models.Find(v) == nil || OpenAI_Upstream(v) == nil {
+			continue
+		}
+		if (plan != nil && !plan.AllowModel(v)) || (api_key != nil && !api_key.AllowModel(v)) {
+			continue
+		}
+		chain = append(chain, v)
+	}
+	return chain
+}
+
+// Request payload of the fallback model, the policy and tokens budget of the model are applied
+func openai_fallback_body(body map[string]any, model_id string) (map[string]any, *OpenAIError) {
+	var payload = map[string]any{}
+	for k, v := range body {
+		payload[k] = v
+	}
+	payload["model"] = model_id
+
+	if _, err := OpenAI_PrepareCompletions(payload, model_id); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// 5xx, timeout or network error
+func openai_fallback_retryable(data *httpx.HTTPData2) bool {
+	if data == nil {
+		return true
+	}
+	if data.ErrorCode == httpx.HTTP_RESULT_ERROR || data.ErrorCode == -2 || data.ErrorCode >= 500 {
+		utils.Logger.LogWarning("[AI] Upstream failed (", data.ErrorCode, ", ", data.ErrorMessage, ")")
+		return true
+	}
+	return false
+}
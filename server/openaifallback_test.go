@@ -0,0 +1,54 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFallbackChain(t *testing.T) {
+	var models, upstreams = OPENAI_Models, aiapi_upstreams
+	defer func() {
+		OPENAI_Models, aiapi_upstreams = models, upstreams
+		OpenAI_FallbackInit(nil)
+	}()
+
+	OPENAI_Models = &OPEMAI_MODELS{Data: []OPENAI_MODEL_ITEM{
+		{ID: "gpt-4"}, {ID: "gpt-4-turbo"}, {ID: "gpt-3.5-turbo"}, {ID: "gpt-3.5-turbo-16k"}, {ID: "claude"},
+	}}
+	if !OpenAI_UpstreamInit(Config{Upstreams: []OpenAIUpstreamConfig{
+		{Name: "test", Url: "http://127.0.0.1:1", Models: []string{"gpt-*"}},
+	}}) {
+		t.Fatal("OpenAI_UpstreamInit() failed")
+	}
+	OpenAI_FallbackInit(map[string][]string{
+		"gpt-4":          {"GPT-4-Turbo ", "unknown", "claude", "gpt-3.5-turbo"},
+		"gpt-3.5-turbo*": {"gpt-3.5-turbo-16k", "gpt-4"},
+	})
+
+	var tests = []struct {
+		name    string
+		model   string
+		plan    *UserPlan
+		api_key *DBAPIKeyData
+		chain   []string
+	}{
+		// Unknown models and the models without an upstream are skipped
+		{"no limits", "gpt-4", nil, nil, []string{"gpt-4", "gpt-4-turbo", "gpt-3.5-turbo"}},
+		{"prefix", "gpt-3.5-turbo-0613", nil, nil, []string{"gpt-3.5-turbo-0613", "gpt-3.5-turbo-16k", "gpt-4"}},
+		{"no fallbacks", "gpt-4-turbo", nil, nil, []string{"gpt-4-turbo"}},
+		// The fallbacks not allowed by the plan or the API key are skipped
+		{"plan", "gpt-3.5-turbo", &UserPlan{Name: "free", Models: []string{"gpt-3.5-turbo*"}}, nil,
+			[]string{"gpt-3.5-turbo", "gpt-3.5-turbo-16k"}},
+		{"plan without limits", "gpt-3.5-turbo", &UserPlan{Name: "pro"}, nil,
+			[]string{"gpt-3.5-turbo", "gpt-3.5-turbo-16k", "gpt-4"}},
+		{"api key", "gpt-4", nil, &DBAPIKeyData{Models: []string{"gpt-4", "gpt-3.5-turbo"}},
+			[]string{"gpt-4", "gpt-3.5-turbo"}},
+		{"plan and api key", "gpt-3.5-turbo", &UserPlan{Name: "free", Models: []string{"gpt-3.5-turbo*"}},
+			&DBAPIKeyData{Models: []string{"gpt-3.5-turbo"}}, []string{"gpt-3.5-turbo"}},
+	}
+	for _, v := range tests {
+		if chain := OpenAI_FallbackChain(v.model, v.plan, v.api_key); !reflect.DeepEqual(chain, v.chain) {
+			t.Errorf("%s: OpenAI_FallbackChain(%q) = %v, want %v", v.name, v.model, chain, v.chain)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	OPENAI_KEYS_ROUND_ROBIN = "round_robin"
+	OPENAI_KEYS_LEAST_USED  = "least_used"
+	// Seconds
+	OPENAI_KEYS_COOLDOWN = 60
+)
+
+type OpenAIUpstreamKey struct {
+	Key string
+	//
+	Requests   int64
+	Failures   int64
+	LastStatus int
+	LastError  string
+	LastTime   time.Time
+	// Quarantined until
+	Quarantine time.Time
+}
+
+// API key pool of an upstream
+type OpenAIUpstreamKeys struct {
+	Selection string
+	Cooldown  time.Duration
+	//
+	lock  sync.Mutex
+	index int
+	list  []*OpenAIUpstreamKey
+}
+
+func NewOpenAIUpstreamKeys(keys []string, selection string, cooldown int) *OpenAIUpstreamKeys {
+	selection = strings.ToLower(strings.TrimSpace(selection))
+	if selection != OPENAI_KEYS_LEAST_USED {
+		selection = OPENAI_KEYS_ROUND_ROBIN
+	}
+	if cooldown <= 0 {
+		cooldown = OPENAI_KEYS_COOLDOWN
+	}
+
+	var pool = &OpenAIUpstreamKeys{
+		Selection: selection,
+		Cooldown:  time.Duration(cooldown) * time.Second,
+		index:     0,
+		list:      []*OpenAIUpstreamKey{},
+	}
+	for _, v := range keys {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		pool.list = append(pool.list, &OpenAIUpstreamKey{Key: v})
+	}
+	return pool
+}
+
+func (I *OpenAIUpstreamKeys) Count() int {
+	return len(I.list)
+}
+
+// Quarantined keys are skipped, when all keys are quarantined the first one to be released is used
+func (I *OpenAIUpstreamKeys) Next() *OpenAIUpstreamKey {
+	I.lock.Lock()
+	defer I.lock.Unlock()
+
+	var count = len(I.list)
+	if count == 0 {
+		return nil
+	}
+
+	var now = time.Now()
+	var key *OpenAIUpstreamKey = nil
+	if I.Selection == OPENAI_KEYS_LEAST_USED {
+		for _, v := range I.list {
+			if v.Quarantine.After(now) {
+				continue
+			}
+			if key == nil || v.Requests < key.Requests {
+				key = v
+			}
+		}
+	} else {
+		for i := 0; i < count; i++ {
+			var v = I.list[(I.index+i)%count]
+			if v.Quarantine.After(now) {
+				continue
+			}
+			key = v
+			I.index = (I.index + i + 1) % count
+			break
+		}
+	}
+
+	if key == nil {
+		for _, v := range I.list {
+			if key == nil || v.Quarantine.Before(key.Quarantine) {
+				key = v
+			}
+		}
+	}
+
+	key.Requests++
+	key.LastTime = now
+	return key
+}
+
+// 401, 429 or insufficient_quota : quarantine the key for the cool-down
+func (I *OpenAIUpstreamKeys) Result(key *OpenAIUpstreamKey, data *httpx.HTTPData2) {
+	if key == nil || data == nil {
+		return
+	}
+
+	I.lock.Lock()
+	defer I.lock.Unlock()
+
+	key.LastStatus = data.ErrorCode
+	if data.ErrorCode == httpx.HTTP_RESULT_OK {
+		key.LastError = ""
+		return
+	}
+
+	var code = openai_error_code(data)
+	if data.ErrorCode == http.StatusUnauthorized || data.ErrorCode == http.StatusTooManyRequests ||
+		code == "insufficient_quota" {
+		key.Failures++
+		key.LastError = data.ErrorMessage
+		if len(code) > 0 {
+			key.LastError = code
+		}
+		key.Quarantine = time.Now().Add(I.Cooldown)
+
+		utils.Logger.LogWarning("[AI] API key (", openai_key_mask(key.Key), ") quarantined (",
+			data.ErrorCode, ", ", key.LastError, ") for ", I.Cooldown)
+	}
+}
+
+func (I *OpenAIUpstreamKeys) Health() []map[string]any {
+	I.lock.Lock()
+	defer I.lock.Unlock()
+
+	var now = time.Now()
+	var list = []map[string]any{}
+	for _, v := range I.list {
+		var status = "ok"
+		var remaining = 0.0
+		if v.Quarantine.After(now) {
+			status = "quarantined"
+			remaining = v.Quarantine.Sub(now).Seconds()
+		}
+
+		var item = map[string]any{
+			"key":         openai_key_mask(v.Key),
+			"status":      status,
+			"requests":    v.Requests,
+			"failures":    v.Failures,
+			"last_status": v.LastStatus,
+			"last_error":  v.LastError,
+			"last_time":   "",
+			"quarantine":  remaining,
+		}
+		if !v.LastTime.IsZero() {
+			item["last_time"] = utils.DateFormat(v.LastTime, 3)
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// "sk-...abcd"
+func openai_key_mask(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[0:3] + "..." + key[len(key)-4:]
+}
+
+// error.code or error.type of the OpenAI error body
+func openai_error_code(data *httpx.HTTPData2) string {
+	body, ok := data.Data().(map[string]any)
+	if !ok {
+		return ""
+	}
+	value, ok := body["error"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	code, ok := value["code"].(string)
+	if ok && len(code) > 0 {
+		return code
+	}
+	code, ok = value["type"].(string)
+	if ok {
+		return code
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const (
+	OPENAI_POLICY_CLAMP  = "clamp"
+	OPENAI_POLICY_REJECT = "reject"
+)
+
+// Sampling parameters policy (config.yaml : openai_model_policies)
+//
+//	openai_model_policies:
+//	  - model: "gpt-4*"
+//	    mode: "reject"
+//	    max_tokens: 4096
+//	    context_tokens: 8192
+//	    context: "summarize"
+//	    context_keep_last: 10
+//	    summary_model: "gpt-3.5-turbo"
+//	    temperature: [0, 1.5]
+type OpenAIModelPolicy struct {
+	// Model id, a trailing '*' matches by prefix ("gpt-4*")
+	Model string `yaml:"model" json:"model"`
+	// Out-of-range values : "clamp" (default) or "reject"
+	Mode string `yaml:"mode" json:"mode"`
+	// Limit of max_tokens, and the value used when the client does not send it
+	MaxTokens        int `yaml:"max_tokens" json:"max_tokens"`
+	DefaultMaxTokens int `yaml:"default_max_tokens" json:"default_max_tokens"`
+	// Context window (prompt and completion tokens)
+	ContextTokens int `yaml:"context_tokens" json:"context_tokens"`
+	// Messages over the context window : "reject" (default), "drop_oldest", "keep_last" or "summarize"
+	// keep_last and summarize keep the system messages and the last messages (context_keep_last),
+	// summarize replaces the older messages by one summary of the cheaper model (summary_model)
+	Context         string `yaml:"context" json:"context"`
+	ContextKeepLast int    `yaml:"context_keep_last" json:"context_keep_last"`
+	SummaryModel    string `yaml:"summary_model" json:"summary_model"`
+	// Ranges [min, max]
+	Temperature      []float64 `yaml:"temperature" json:"temperature"`
+	TopP             []float64 `yaml:"top_p" json:"top_p"`
+	PresencePenalty  []float64 `yaml:"presence_penalty" json:"presence_penalty"`
+	FrequencyPenalty []float64 `yaml:"frequency_penalty" json:"frequency_penalty"`
+	// Max count of stop sequences
+	MaxStop int `yaml:"max_stop" json:"max_stop"`
+	// Values used only when the field is missing
+	Defaults map[string]any `yaml:"defaults" json:"defaults"`
+}
+
+// OpenAI style error
+//
+//	{
+//		"error": {
+//			"message": "...",
+//			"type": "invalid_request_error",
+//			"param": "temperature",
+//			"code": null
+//		}
+//	}
+type OpenAIError struct {
+	Status  int
+	Message string
+	Type    string
+	Param   string
+	Code    string
+}
+
+func (I *OpenAIError) Error() string {
+	return I.Message
+}
+
+func NewOpenAIError(status int, error_type string, param string, message string) *OpenAIError {
+	return &OpenAIError{
+		Status:  status,
+		Message: message,
+		Type:    error_type,
+		Param:   param,
+	}
+}
+
+var openai_policies []OpenAIModelPolicy = []OpenAIModelPolicy{}
+
+func OpenAI_PolicyInit(policies []OpenAIModelPolicy) bool {
+	openai_policies = []OpenAIModelPolicy{}
+	for _, v := range policies {
+		v.Model = strings.ToLower(strings.TrimSpace(v.Model))
+		v.Mode = strings.ToLower(strings.TrimSpace(v.Mode))
+		if len(v.Model) == 0 {
+			continue
+		}
+		if v.Mode != OPENAI_POLICY_REJECT {
+			v.Mode = OPENAI_POLICY_CLAMP
+		}
+		openai_policies = append(openai_policies, v)
+	}
+	return true
+}
+
+// "gpt-4" : equal
+// "gpt-4*" : prefix
+func model_match(pattern string, id string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(id, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == id
+}
+
+// Default policy (OpenAI API reference)
+func openai_policy_default(model_id string) OpenAIModelPolicy {
+	// ChatGPT-3 : 4096 tokens
+	var policy = OpenAIModelPolicy{
+		Model:     model_id,
+		Mode:      OPENAI_POLICY_CLAMP,
+		MaxTokens: 2048,
+	}
+	// ChatGPT-4 : 8192 tokens
+	if strings.Contains(model_id, "gpt-4") {
+		policy.MaxTokens = 4096
+	}
+	return policy
+}
+
+func OpenAI_Policy(model_id string) *OpenAIModelPolicy {
+	var policy = openai_policy_default(model_id)
+	for _, v := range openai_policies {
+		if model_match(v.Model, model_id) {
+			policy = v
+			break
+		}
+	}
+
+	if policy.MaxTokens <= 0 {
+		policy.MaxTokens = openai_policy_default(model_id).MaxTokens
+	}
+	if policy.ContextTokens <= 0 {
+		policy.ContextTokens = openai_context_tokens(model_id)
+	}
+	policy.Context = openai_context_strategy(policy.Context)
+	if policy.ContextKeepLast <= 0 {
+		policy.ContextKeepLast = OPENAI_CONTEXT_KEEP_LAST_DEFAULT
+	}
+	policy.SummaryModel = strings.ToLower(strings.TrimSpace(policy.SummaryModel))
+	if len(policy.SummaryModel) == 0 {
+		policy.SummaryModel = OPENAI_CONTEXT_SUMMARY_MODEL
+	}
+	if policy.DefaultMaxTokens <= 0 || policy.DefaultMaxTokens > policy.MaxTokens {
+		policy.DefaultMaxTokens = policy.MaxTokens
+	}
+	if len(policy.Temperature) != 2 {
+		policy.Temperature = []float64{0, 2}
+	}
+	if len(policy.TopP) != 2 {
+		policy.TopP = []float64{0, 1}
+	}
+	if len(policy.PresencePenalty) != 2 {
+		policy.PresencePenalty = []float64{-2, 2}
+	}
+	if len(policy.FrequencyPenalty) != 2 {
+		policy.FrequencyPenalty = []float64{-2, 2}
+	}
+	if policy.MaxStop <= 0 {
+		policy.MaxStop = 4
+	}
+	return &policy
+}
+
+// JSON numbers are float64, YAML defaults may be int
+func to_number(value any) (float64, bool) {
+	switch value.(type) {
+	case float64:
+		return value.(float64), !math.IsNaN(value.(float64))
+	case float32:
+		return float64(value.(float32)), true
+	case int:
+		return float64(value.(int)), true
+	case int64:
+		return float64(value.(int64)), true
+	}
+	return 0, false
+}
+
+func (I *OpenAIModelPolicy) check_range(body map[string]any, key string, limits []float64, def float64) *OpenAIError {
+	value, ok := body[key]
+	if !ok || value == nil {
+		body[key] = def
+		return nil
+	}
+
+	number, ok := to_number(value)
+	if !ok {
+		return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", key,
+			fmt.Sprintf("'%v' is not of type 'number' - '%s'", value, key))
+	}
+
+	if number >= limits[0] && number <= limits[1] {
+		return nil
+	}
+	if I.Mode == OPENAI_POLICY_REJECT {
+		return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", key,
+			fmt.Sprintf("%v is out of range [%v, %v] - '%s'", number, limits[0], limits[1], key))
+	}
+	body[key] = math.Max(limits[0], math.Min(limits[1], number))
+	return nil
+}
+
+func (I *OpenAIModelPolicy) check_max_tokens(body map[string]any) *OpenAIError {
+	value, ok := body["max_tokens"]
+	if !ok || value == nil {
+		body["max_tokens"] = I.DefaultMaxTokens
+		return nil
+	}
+
+	number, ok := to_number(value)
+	if !ok || number != math.Trunc(number) {
+		return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "max_tokens",
+			fmt.Sprintf("'%v' is not of type 'integer' - 'max_tokens'", value))
+	}
+
+	if number >= 1 && int(number) <= I.MaxTokens {
+		body["max_tokens"] = int(number)
+		return nil
+	}
+	if I.Mode == OPENAI_POLICY_REJECT {
+		return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "max_tokens",
+			fmt.Sprintf("max_tokens is too large: %d. This model supports at most %d completion tokens.", int(number), I.MaxTokens))
+	}
+	body["max_tokens"] = int(math.Max(1, math.Min(float64(I.MaxTokens), number)))
+	return nil
+}
+
+func (I *OpenAIModelPolicy) check_stop(body map[string]any) *OpenAIError {
+	value, ok := body["stop"]
+	if !ok || value == nil {
+		body["stop"] = nil
+		return nil
+	}
+
+	switch value.(type) {
+	case string:
+		return nil
+	case []any:
+		var list = value.([]any)
+		for _, v := range list {
+			if _, ok := v.(string); !ok {
+				return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "stop",
+					fmt.Sprintf("'%v' is not of type 'string' - 'stop'", v))
+			}
+		}
+		if len(list) <= I.MaxStop {
+			return nil
+		}
+		if I.Mode == OPENAI_POLICY_REJECT {
+			return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "stop",
+				fmt.Sprintf("%d is greater than the maximum of %d - 'stop'", len(list), I.MaxStop))
+		}
+		body["stop"] = list[0:I.MaxStop]
+		return nil
+	}
+	return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "stop",
+		fmt.Sprintf("'%v' is not valid under any of the given schemas - 'stop'", value))
+}
+
+// Validate the client sampling parameters, defaults only fill missing fields
+func (I *OpenAIModelPolicy) Apply(body map[string]any) *OpenAIError {
+	for k, v := range I.Defaults {
+		if _, ok := body[k]; !ok {
+			body[k] = v
+		}
+	}
+
+	var err *OpenAIError = nil
+	if err = I.check_range(body, "temperature", I.Temperature, 1); err != nil {
+		return err
+	}
+	if err = I.check_range(body, "top_p", I.TopP, 1); err != nil {
+		return err
+	}
+	if err = I.check_range(body, "presence_penalty", I.PresencePenalty, 0); err != nil {
+		return err
+	}
+	if err = I.check_range(body, "frequency_penalty", I.FrequencyPenalty, 0); err != nil {
+		return err
+	}
+	if err = I.check_max_tokens(body); err != nil {
+		return err
+	}
+	if err = I.check_stop(body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sampling parameters and tokens budget of the chat request, return the prompt tokens
+func OpenAI_PrepareCompletions(body map[string]any, model_id string) (int, *OpenAIError) {
+	var policy = OpenAI_Policy(model_id)
+	if err := policy.Apply(body); err != nil {
+		return 0, err
+	}
+	return policy.ApplyTokens(body)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModelMatch(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		id      string
+		ok      bool
+	}{
+		{"gpt-4", "gpt-4", true},
+		{"gpt-4", "gpt-4-32k", false},
+		{"gpt-4*", "gpt-4-32k", true},
+		{"gpt-4*", "gpt-3.5-turbo", false},
+		{"*", "gpt-3.5-turbo", true},
+	}
+	for _, v := range tests {
+		if ok := model_match(v.pattern, v.id); ok != v.ok {
+			t.Errorf("model_match(%q, %q) = %v, want %v", v.pattern, v.id, ok, v.ok)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	OpenAI_PolicyInit([]OpenAIModelPolicy{
+		{Model: "clamp-*", MaxTokens: 100, Temperature: []float64{0.5, 1.5}, MaxStop: 2,
+			Defaults: map[string]any{"temperature": 0.7}},
+		{Model: "REJECT-*", Mode: "REJECT", MaxTokens: 100, DefaultMaxTokens: 50, Temperature: []float64{0.5, 1.5}, MaxStop: 2},
+	})
+	defer OpenAI_PolicyInit(nil)
+
+	var tests = []struct {
+		name   string
+		model  string
+		body   map[string]any
+		param  string
+		expect map[string]any
+	}{
+		{"defaults", "clamp-1", map[string]any{},
+			"", map[string]any{"temperature": 0.7, "top_p": float64(1), "presence_penalty": float64(0),
+				"frequency_penalty": float64(0), "max_tokens": 100, "stop": nil}},
+		{"clamp low", "clamp-1", map[string]any{"temperature": float64(0)},
+			"", map[string]any{"temperature": 0.5}},
+		{"clamp high", "clamp-1", map[string]any{"temperature": float64(2), "top_p": float64(3)},
+			"", map[string]any{"temperature": 1.5, "top_p": float64(1)}},
+		{"clamp max_tokens", "clamp-1", map[string]any{"max_tokens": float64(1000)},
+			"", map[string]any{"max_tokens": 100}},
+		{"clamp max_tokens low", "clamp-1", map[string]any{"max_tokens": float64(-5)},
+			"", map[string]any{"max_tokens": 1}},
+		{"clamp stop", "clamp-1", map[string]any{"stop": []any{"a", "b", "c"}},
+			"", map[string]any{"stop": []any{"a", "b"}}},
+		{"stop string", "clamp-1", map[string]any{"stop": "a"},
+			"", map[string]any{"stop": "a"}},
+		{"in range", "reject-1", map[string]any{"temperature": float64(1), "max_tokens": float64(10)},
+			"", map[string]any{"temperature": float64(1), "max_tokens": 10}},
+		{"default max_tokens", "reject-1", map[string]any{},
+			"", map[string]any{"max_tokens": 50}},
+		{"reject temperature", "reject-1", map[string]any{"temperature": float64(2)}, "temperature", nil},
+		{"reject max_tokens", "reject-1", map[string]any{"max_tokens": float64(101)}, "max_tokens", nil},
+		{"reject stop", "reject-1", map[string]any{"stop": []any{"a", "b", "c"}}, "stop", nil},
+		{"not a number", "clamp-1", map[string]any{"top_p": "1"}, "top_p", nil},
+		{"not an integer", "clamp-1", map[string]any{"max_tokens": 1.5}, "max_tokens", nil},
+		{"stop not a string", "clamp-1", map[string]any{"stop": []any{"a", float64(1)}}, "stop", nil},
+	}
+
+	for _, v := range tests {
+		var err = OpenAI_Policy(v.model).Apply(v.body)
+		if len(v.param) > 0 {
+			if err == nil || err.Param != v.param {
+				t.Errorf("%s: Apply() = %v, want an error of '%s'", v.name, err, v.param)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Apply() = %v", v.name, err.Message)
+			continue
+		}
+		for key, value := range v.expect {
+			if !reflect.DeepEqual(v.body[key], value) {
+				t.Errorf("%s: %s = %#v, want %#v", v.name, key, v.body[key], value)
+			}
+		}
+	}
+}
+
+func TestPolicyDefault(t *testing.T) {
+	OpenAI_PolicyInit(nil)
+
+	var policy = OpenAI_Policy("gpt-4-0613")
+	if policy.MaxTokens != 4096 || policy.Mode != OPENAI_POLICY_CLAMP || policy.Context != OPENAI_CONTEXT_REJECT ||
+		policy.ContextKeepLast != OPENAI_CONTEXT_KEEP_LAST_DEFAULT || policy.MaxStop != 4 {
+		t.Errorf("OpenAI_Policy(gpt-4) = %+v", policy)
+	}
+	if policy = OpenAI_Policy("gpt-3.5-turbo"); policy.MaxTokens != 2048 || policy.DefaultMaxTokens != 2048 {
+		t.Errorf("OpenAI_Policy(gpt-3.5-turbo) = %+v", policy)
+	}
+}
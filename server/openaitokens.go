@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"mcmcx.com/gpt-server/tokenizer"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const OPENAI_TOKENIZER_FILE = "cl100k_base.tiktoken"
+
+var openai_tokenizer *tokenizer.Encoding = nil
+
+// Without the vocabulary file the tokens are estimated (tokenizer.Estimate, an upper estimate),
+// the prompt budgets are still enforced but the counts are not exact
+func OpenAI_TokenizerInit(filename string) bool {
+	if len(filename) == 0 {
+		filename = OPENAI_TOKENIZER_FILE
+	}
+
+	encoding, err := tokenizer.NewEncodingFromFile(tokenizer.CL100K_BASE, filename)
+	if err != nil {
+		utils.Logger.LogError("[AI] Tokenizer (", filename, ") loading failure, the prompt tokens are estimated: ", err)
+		openai_tokenizer = nil
+		return true
+	}
+
+	openai_tokenizer = encoding
+	utils.Logger.Log("[AI] Tokenizer (", encoding.Name, ", Size:", encoding.Size(), ")")
+	return true
+}
+
+func OpenAI_TokenizerLoaded() bool {
+	return openai_tokenizer != nil
+}
+
+func OpenAI_CountText(text string) int {
+	if len(text) == 0 {
+		return 0
+	}
+	if openai_tokenizer == nil {
+		return tokenizer.Estimate(text)
+	}
+	return openai_tokenizer.Count(text)
+}
+
+func OpenAI_EncodeText(text string) []int {
+	if openai_tokenizer == nil {
+		return []int{}
+	}
+	return openai_tokenizer.Encode(text)
+}
+
+// Text of the message content, string or array of parts
+func openai_message_content(content any) (string, int) {
+	switch content.(type) {
+	case string:
+		return content.(string), 0
+	case []any:
+		var text = ""
+		var images = 0
+		for _, v := range content.([]any) {
+			part, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			if value, ok := part["text"].(string); ok {
+				text += value
+			}
+			if part["type"] == "image_url" {
+				images++
+			}
+		}
+		return text, images
+	}
+	return "", 0
+}
+
+// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+// every message : <|start|>{role/name}\n{content}<|end|>\n
+// every reply is primed with <|start|>assistant<|message|>
+func OpenAI_CountMessages(messages []any) int {
+	const tokens_per_message = 3
+	const tokens_per_name = 1
+	// Image (low detail)
+	const tokens_per_image = 85
+
+	var count = 0
+	for _, v := range messages {
+		message, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		count += tokens_per_message
+		for key, value := range message {
+			switch key {
+			case "content":
+				text, images := openai_message_content(value)
+				count += OpenAI_CountText(text) + images*tokens_per_image
+			case "name":
+				text, _ := value.(string)
+				count += OpenAI_CountText(text) + tokens_per_name
+			case "role":
+				text, _ := value.(string)
+				count += OpenAI_CountText(text)
+			default:
+				// function_call, tool_calls ...
+				if value == nil {
+					continue
+				}
+				bytes, err := json.Marshal(value)
+				if err == nil {
+					count += OpenAI_CountText(string(bytes))
+				}
+			}
+		}
+	}
+	count += 3
+	return count
+}
+
+// Context window of the model (tokens)
+func openai_context_tokens(model_id string) int {
+	switch {
+	case strings.HasPrefix(model_id, "gpt-4-32k"):
+		return 32768
+	case strings.HasPrefix(model_id, "gpt-4-1106"), strings.HasPrefix(model_id, "gpt-4-0125"),
+		strings.HasPrefix(model_id, "gpt-4-turbo"), strings.HasPrefix(model_id, "gpt-4-vision"):
+		return 128000
+	case strings.HasPrefix(model_id, "gpt-4"):
+		return 8192
+	case strings.HasPrefix(model_id, "gpt-3.5-turbo-16k"), strings.HasPrefix(model_id, "gpt-3.5-turbo-1106"),
+		strings.HasPrefix(model_id, "gpt-3.5-turbo-0125"):
+		return 16385
+	}
+	return 4096
+}
+
+// Prompt tokens of the chat request, rejects requests over the context window,
+// max_tokens is limited to the remaining budget
+func (I *OpenAIModelPolicy) ApplyTokens(body map[string]any) (int, *OpenAIError) {
+	messages, ok := body["messages"].([]any)
+	if !ok || len(messages) == 0 {
+		return 0, NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "messages",
+			"'messages' is a required property")
+	}
+
+	var prompt_tokens = OpenAI_CountMessages(messages)
+	if prompt_tokens >= I.ContextTokens {
+		var err = NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "messages",
+			fmt.Sprintf("This model's maximum context length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.",
+				I.ContextTokens, prompt_tokens))
+		err.Code = "context_length_exceeded"
+		return prompt_tokens, err
+	}
+
+	max_tokens, ok := to_number(body["max_tokens"])
+	var remaining = I.ContextTokens - prompt_tokens
+	if !ok || int(max_tokens) > remaining {
+		body["max_tokens"] = remaining
+	}
+	return prompt_tokens, nil
+}
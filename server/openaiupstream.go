@@ -0,0 +1,241 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"mcmcx.com/gpt-server/httpx"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	OPENAI_UPSTREAM_OPENAI = "openai"
+	OPENAI_UPSTREAM_AZURE  = "azure"
+)
+
+// Upstream providers (config.yaml : openai_upstreams)
+//
+//	openai_upstreams:
+//	  - name: "openai"
+//	    url: "https://api.openai.com/"
+//	    keys: ["sk-...", "sk-..."]
+//	    key_selection: "round_robin"
+//	    key_cooldown: 60
+//	    org: "org-..."
+//	    models: ["gpt-*", "text-embedding-*", "dall-e-*", "whisper-1", "tts-*"]
+//	  - name: "azure"
+//	    type: "azure"
+//	    url: "https://<resource>.openai.azure.com/"
+//	    key: "..."
+//	    api_version: "2023-12-01-preview"
+//	    deployments: { "gpt-4-32k": "gpt4-32k" }
+//	    models: ["gpt-4-32k"]
+type OpenAIUpstreamConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// "openai" (default, OpenAI compatible servers) or "azure"
+	Type string `yaml:"type" json:"type"`
+	Url  string `yaml:"url" json:"url"`
+	Key  string `yaml:"key" json:"key"`
+	// API key pool, selection : "round_robin" (default) or "least_used"
+	// Keys failed with 401, 429 or insufficient_quota are quarantined for key_cooldown seconds
+	Keys         []string          `yaml:"keys" json:"keys"`
+	KeySelection string            `yaml:"key_selection" json:"key_selection"`
+	KeyCooldown  int               `yaml:"key_cooldown" json:"key_cooldown"`
+	Organization string            `yaml:"org" json:"org"`
+	Headers      map[string]string `yaml:"headers" json:"headers"`
+	// Model ids served by the upstream, a trailing '*' matches by prefix
+	Models []string `yaml:"models" json:"models"`
+	// Azure : api-version and deployment name of the model (default: model id without '.')
+	APIVersion  string            `yaml:"api_version" json:"api_version"`
+	Deployments map[string]string `yaml:"deployments" json:"deployments"`
+}
+
+type OpenAIUpstream struct {
+	Name   string
+	Type   string
+	Config OpenAIUpstreamConfig
+	Client *httpx.HTTPClient2
+	Keys   *OpenAIUpstreamKeys
+}
+
+var aiapi_upstreams []*OpenAIUpstream = []*OpenAIUpstream{}
+
+func NewOpenAIUpstream(config OpenAIUpstreamConfig) *OpenAIUpstream {
+	config.Name = strings.TrimSpace(config.Name)
+	config.Type = strings.ToLower(strings.TrimSpace(config.Type))
+	if config.Type != OPENAI_UPSTREAM_AZURE {
+		config.Type = OPENAI_UPSTREAM_OPENAI
+	}
+	if len(config.Url) == 0 {
+		return nil
+	}
+	if len(config.Models) == 0 {
+		config.Models = []string{"*"}
+	}
+	for i, v := range config.Models {
+		config.Models[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+
+	var keys = config.Keys
+	if len(config.Key) > 0 {
+		keys = append([]string{config.Key}, keys...)
+	}
+
+	// The API key is set per request
+	var additional_headers map[string]string = map[string]string{}
+	if config.Type != OPENAI_UPSTREAM_AZURE && len(config.Organization) > 0 {
+		additional_headers["Openai-Organization"] = config.Organization
+	}
+	for k, v := range config.Headers {
+		additional_headers[k] = v
+	}
+
+	client := httpx.NewClient(config.Url, additional_headers)
+	if client == nil {
+		return nil
+	}
+
+	return &OpenAIUpstream{
+		Name:   config.Name,
+		Type:   config.Type,
+		Config: config,
+		Client: client,
+		Keys:   NewOpenAIUpstreamKeys(keys, config.KeySelection, config.KeyCooldown),
+	}
+}
+
+func OpenAI_UpstreamInit(config Config) bool {
+	var list = config.Upstreams
+	// Compatible : openai_api_url, openai_api_key, openai_api_org
+	if len(list) == 0 {
+		list = []OpenAIUpstreamConfig{
+			{
+				Name:         "openai",
+				Url:          config.APIUrl,
+				Key:          config.APIKey,
+				Organization: config.APIOrganization,
+			},
+		}
+	}
+
+	aiapi_upstreams = []*OpenAIUpstream{}
+	for i, v := range list {
+		if len(v.Name) == 0 {
+			v.Name = fmt.Sprintf("upstream%d", i)
+		}
+		var upstream = NewOpenAIUpstream(v)
+		if upstream == nil {
+			utils.Logger.LogError("[AI] Upstream (", v.Name, ") config error.")
+			return false
+		}
+		aiapi_upstreams = append(aiapi_upstreams, upstream)
+		utils.Logger.Log("[AI] Upstream (", upstream.Name, ", ", upstream.Type, ", ", upstream.Config.Url,
+			", Keys:", upstream.Keys.Count(), ") Models:", upstream.Config.Models)
+	}
+	return len(aiapi_upstreams) > 0
+}
+
+func (I *OpenAIUpstream) Serves(model_id string) bool {
+	for _, v := range I.Config.Models {
+		if v == "*" || model_match(v, model_id) {
+			return true
+		}
+	}
+	return false
+}
+
+// The first upstream (config order) serving the model
+func OpenAI_Upstream(model_id string) *OpenAIUpstream {
+	for _, v := range aiapi_upstreams {
+		if v.Serves(model_id) {
+			return v
+		}
+	}
+	return nil
+}
+
+func (I *OpenAIUpstream) Deployment(model_id string) string {
+	deployment, ok := I.Config.Deployments[model_id]
+	if !ok || len(deployment) == 0 {
+		deployment = strings.ReplaceAll(model_id, ".", "")
+	}
+	return deployment
+}
+
+// endpoint : "chat/completions", "embeddings", ...
+func (I *OpenAIUpstream) HTTPRequest2(endpoint string, model_id string, data *httpx.HTTPData2) *httpx.HTTPData2 {
+	var key = I.Keys.Next()
+	if key != nil {
+		if data.Headers == nil {
+			data.Headers = map[string]string{}
+		}
+		if I.Type == OPENAI_UPSTREAM_AZURE {
+			data.Headers["api-key"] = key.Key
+		} else {
+			data.Headers["Authorization"] = fmt.Sprintf("Bearer %s", key.Key)
+		}
+	}
+
+	var path = "/v1/" + endpoint
+	var params map[string]any = nil
+	if I.Type == OPENAI_UPSTREAM_AZURE {
+		params = map[string]any{
+			"api-version": I.Config.APIVersion,
+		}
+		path = "/openai/deployments/" + I.Deployment(model_id) + "/" + endpoint
+		if len(model_id) == 0 {
+			path = "/openai/" + endpoint
+		}
+	}
+
+	var result = I.Client.HTTPRequest2(path, params, data)
+	I.Keys.Result(key, data)
+	return result
+}
+
+// Models of all upstreams, configured model ids are added when the upstream can not list them
+func OpenAI_InitModels() bool {
+	var models = OPEMAI_MODELS{
+		Object: "list",
+		Data:   []OPENAI_MODEL_ITEM{},
+	}
+	var exists = map[string]bool{}
+
+	for _, upstream := range aiapi_upstreams {
+		var list = []OPENAI_MODEL_ITEM{}
+		if upstream.Type == OPENAI_UPSTREAM_OPENAI {
+			var data = API_GPTModels2(upstream)
+			if data.ErrorCode == httpx.HTTP_RESULT_OK && OpenAI_Init(data.Data()) {
+				list = OPENAI_Models.Data
+			} else {
+				utils.Logger.LogWarning("[AI] Upstream (", upstream.Name, ") loading models failure.")
+			}
+		}
+
+		for _, v := range upstream.Config.Models {
+			if v == "*" || strings.HasSuffix(v, "*") {
+				continue
+			}
+			list = append(list, OPENAI_MODEL_ITEM{
+				Created: time.Now().Unix(),
+				ID:      v,
+				Object:  "model",
+				Owned:   upstream.Name,
+				Root:    v,
+			})
+		}
+
+		for _, v := range list {
+			// Served by the first matching upstream
+			if exists[v.ID] || OpenAI_Upstream(v.ID) != upstream {
+				continue
+			}
+			exists[v.ID] = true
+			models.Data = append(models.Data, v)
+		}
+	}
+
+	OPENAI_Models = &models
+	return len(models.Data) > 0
+}
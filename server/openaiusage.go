@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Days (seconds) of the usage counters
+	OPENAI_USAGE_DAY_KEEP   = 100 * 24 * 3600
+	OPENAI_USAGE_MONTH_KEEP = 400 * 24 * 3600
+	// Cost is counted in micro-USD
+	OPENAI_USAGE_COST_UNIT = 1000000
+)
+
+// Prices (config.yaml : openai_model_prices), USD per 1K tokens, a trailing '*' matches by prefix
+//
+//	openai_model_prices:
+//	  - model: "gpt-4*"
+//	    prompt: 0.03
+//	    completion: 0.06
+type OpenAIModelPrice struct {
+	Model      string  `yaml:"model" json:"model"`
+	Prompt     float64 `yaml:"prompt" json:"prompt"`
+	Completion float64 `yaml:"completion" json:"completion"`
+}
+
+var openai_prices []OpenAIModelPrice = []OpenAIModelPrice{}
+
+func OpenAI_PriceInit(prices []OpenAIModelPrice) bool {
+	openai_prices = []OpenAIModelPrice{}
+	for _, v := range prices {
+		v.Model = strings.ToLower(strings.TrimSpace(v.Model))
+		if len(v.Model) == 0 {
+			continue
+		}
+		openai_prices = append(openai_prices, v)
+	}
+	return true
+}
+
+// Exact id, or the longest prefix
+func OpenAI_Price(model_id string) *OpenAIModelPrice {
+	var price *OpenAIModelPrice = nil
+	for i, v := range openai_prices {
+		if v.Model == model_id {
+			return &openai_prices[i]
+		}
+		if model_match(v.Model, model_id) && (price == nil || len(v.Model) > len(price.Model)) {
+			price = &openai_prices[i]
+		}
+	}
+	return price
+}
+
+// Usage of one chat request, the upstream usage is used when it is reported,
+// otherwise the completion tokens are counted from the streamed content
+type OpenAIUsage struct {
+	IDX              utils.TIDX
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	// Reported by the upstream (usage)
+	HasUsage bool
+	//
+	content strings.Builder
+	line    []byte
+}
+
+func NewOpenAIUsage(idx utils.TIDX, model_id string, prompt_tokens int) *OpenAIUsage {
+	return &OpenAIUsage{
+		IDX:          idx,
+		Model:        model_id,
+		PromptTokens: prompt_tokens,
+	}
+}
+
+// Body of chat.completion (non-streaming)
+func (I *OpenAIUsage) ParseResult(result map[string]any) {
+	I.parse_usage(result["usage"])
+	choices, _ := result["choices"].([]any)
+	for _, v := range choices {
+		choice, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		message, ok := choice["message"].(map[string]any)
+		if !ok {
+			continue
+		}
+		text, _ := openai_message_content(message["content"])
+		I.content.WriteString(text)
+	}
+}
+
+// SSE bytes (chat.completion.chunk), the lines may be split across chunks
+func (I *OpenAIUsage) ParseStream(buffer []byte) {
+	I.line = append(I.line, buffer...)
+	for {
+		var pos = bytes.IndexByte(I.line, '\n')
+		if pos < 0 {
+			break
+		}
+		I.parse_line(I.line[0:pos])
+		I.line = I.line[pos+1:]
+	}
+}
+
+func (I *OpenAIUsage) parse_line(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	line = bytes.TrimSpace(line[5:])
+	if len(line) == 0 || string(line) == "[DONE]" {
+		return
+	}
+
+	var chunk map[string]any
+	if json.Unmarshal(line, &chunk) != nil {
+		return
+	}
+	if I.parse_usage(chunk["usage"]) {
+		return
+	}
+	choices, _ := chunk["choices"].([]any)
+	for _, v := range choices {
+		choice, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		delta, ok := choice["delta"].(map[string]any)
+		if !ok {
+			continue
+		}
+		if text, ok := delta["content"].(string); ok {
+			I.content.WriteString(text)
+		}
+	}
+}
+
+func (I *OpenAIUsage) parse_usage(value any) bool {
+	usage, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	prompt_tokens, ok1 := to_number(usage["prompt_tokens"])
+	completion_tokens, ok2 := to_number(usage["completion_tokens"])
+	if !ok1 || !ok2 {
+		return false
+	}
+	I.PromptTokens = int(prompt_tokens)
+	I.CompletionTokens = int(completion_tokens)
+	I.HasUsage = true
+	return true
+}
+
+// Completion tokens of the accumulated content when the upstream has no usage
+func (I *OpenAIUsage) Finish() {
+	if len(I.line) > 0 {
+		I.parse_line(I.line)
+		I.line = nil
+	}
+	if !I.HasUsage {
+		I.CompletionTokens = OpenAI_CountText(I.content.String())
+	}
+}
+
+// Content of the reply (accumulated)
+func (I *OpenAIUsage) Content() string {
+	return I.content.String()
+}
+
+// Micro-USD
+func (I *OpenAIUsage) Cost() int64 {
+	var price = OpenAI_Price(I.Model)
+	if price == nil {
+		return 0
+	}
+	var cost = (float64(I.PromptTokens)*price.Prompt + float64(I.CompletionTokens)*price.Completion) / 1000
+	return int64(math.Round(cost * OPENAI_USAGE_COST_UNIT))
+}
+
+// Counters : usage_user_<idx>_<yyyymmdd> and usage_user_<idx>_<yyyymm>,
+// fields : <model>:requests, <model>:prompt_tokens, <model>:completion_tokens, <model>:cost
+func (I *OpenAIUsage) Save() bool {
+	if I.IDX == 0 || len(I.Model) == 0 {
+		return false
+	}
+
+	var values = map[string]int64{
+		I.Model + ":requests":          1,
+		I.Model + ":prompt_tokens":     int64(I.PromptTokens),
+		I.Model + ":completion_tokens": int64(I.CompletionTokens),
+		I.Model + ":cost":              I.Cost(),
+	}
+
+	var now = time.Now()
+	if !database_redis.IncrFields(db_usage_id(I.IDX, now.Format("20060102")), values, OPENAI_USAGE_DAY_KEEP) ||
+		!database_redis.IncrFields(db_usage_id(I.IDX, now.Format("200601")), values, OPENAI_USAGE_MONTH_KEEP) {
+		utils.Logger.LogError("[AI] Usage (IDX:", I.IDX, ", Model:", I.Model, ") saving failure.")
+		return false
+	}
+	return true
+}
+
+// Usage of the embeddings, images and audio requests (no chat usage to parse),
+// the tokens of the text input (prompt) and output (completion) are counted against the plan quota
+func OpenAI_UsageSave(idx utils.TIDX, model_id string, prompt_tokens int, completion_tokens int) bool {
+	var usage = NewOpenAIUsage(idx, model_id, prompt_tokens)
+	usage.CompletionTokens = completion_tokens
+	usage.HasUsage = true
+	return usage.Save()
+}
+
+func db_usage_id(idx utils.TIDX, date string) string {
+	return fmt.Sprintf("usage_user_%d_%s", idx, date)
+}
+
+// Usage of the day (yyyymmdd) or month (yyyymm) per model
+type OpenAIUsageItem struct {
+	Model            string  `json:"model"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func OpenAI_UsageLoad(idx utils.TIDX, date string) map[string]*OpenAIUsageItem {
+	var items = map[string]*OpenAIUsageItem{}
+	fields, ok := database_redis.GetFields(db_usage_id(idx, date))
+	if !ok {
+		return items
+	}
+
+	for k, v := range fields {
+		var pos = strings.LastIndex(k, ":")
+		if pos <= 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var model_id = k[0:pos]
+		item, ok := items[model_id]
+		if !ok {
+			item = &OpenAIUsageItem{Model: model_id}
+			items[model_id] = item
+		}
+		switch k[pos+1:] {
+		case "requests":
+			item.Requests = value
+		case "prompt_tokens":
+			item.PromptTokens = value
+		case "completion_tokens":
+			item.CompletionTokens = value
+		case "cost":
+			item.Cost = float64(value) / OPENAI_USAGE_COST_UNIT
+		}
+		item.TotalTokens = item.PromptTokens + item.CompletionTokens
+	}
+	return items
+}
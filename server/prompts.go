@@ -0,0 +1,304 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	PROMPT_NAME_MAX    = 64
+	PROMPT_CONTENT_MAX = 32768
+	// Groups of the system prefixes : all users, role:<role>, plan:<plan>
+	PROMPT_GROUP_ALL  = "*"
+	PROMPT_GROUP_ROLE = "role:"
+	PROMPT_GROUP_PLAN = "plan:"
+)
+
+// Prompt template (config.yaml : prompt_templates, or the YAML files of prompt_templates_dir),
+// {{name}} : variable of the chat request (variables), or the default value
+//
+//	prompt_templates:
+//	  - name: "translator"
+//	    content: "Translate the messages into {{language}}. Today is {{date}}."
+//	    variables:
+//	      language: "English"
+//
+// {{date}} (yyyy-mm-dd) and {{model}} are built in
+type PromptTemplate struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+	Content     string `yaml:"content" json:"content"`
+	// Default values of the variables
+	Variables map[string]string `yaml:"variables" json:"variables"`
+	// Saved by the administrators (prompt_template_<name>), overrides the config template
+	UpdateTime string `yaml:"-" json:"update_time,omitempty"`
+}
+
+var prompt_templates map[string]*PromptTemplate = map[string]*PromptTemplate{}
+var prompt_prefixes map[string]string = map[string]string{}
+
+var prompt_name_regex = regexp.MustCompile("^[0-9a-z_.-]+$")
+var prompt_variable_regex = regexp.MustCompile(`\{\{\s*([0-9a-zA-Z_.-]+)\s*\}\}`)
+
+func prompt_name_check(name string) bool {
+	return len(name) > 0 && len(name) <= PROMPT_NAME_MAX && prompt_name_regex.MatchString(name)
+}
+
+func prompt_group(group string) string {
+	group = strings.ToLower(strings.TrimSpace(group))
+	switch {
+	case group == PROMPT_GROUP_ALL:
+		return group
+	case strings.HasPrefix(group, PROMPT_GROUP_ROLE) && IsUserRole(strings.TrimPrefix(group, PROMPT_GROUP_ROLE)):
+		return group
+	case strings.HasPrefix(group, PROMPT_GROUP_PLAN) && len(group) > len(PROMPT_GROUP_PLAN):
+		return group
+	}
+	return ""
+}
+
+func prompt_template_add(template PromptTemplate, source string) bool {
+	template.Name = strings.ToLower(strings.TrimSpace(template.Name))
+	if !prompt_name_check(template.Name) || len(strings.TrimSpace(template.Content)) == 0 {
+		utils.Logger.LogError("[Prompt] Template (", template.Name, ") of ", source, " invalidate.")
+		return false
+	}
+	prompt_templates[template.Name] = &template
+	return true
+}
+
+// Templates of the config and the YAML files (one template per file, the default name is the file name),
+// mandatory system prefixes per group (config.yaml : prompt_prefixes)
+func Prompt_Init(config Config) bool {
+	prompt_templates = map[string]*PromptTemplate{}
+	for _, v := range config.PromptTemplates {
+		if !prompt_template_add(v, "config.yaml") {
+			return false
+		}
+	}
+
+	if len(config.PromptTemplatesDir) > 0 {
+		files, err := filepath.Glob(filepath.Join(config.PromptTemplatesDir, "*.yaml"))
+		if err != nil {
+			utils.Logger.LogError("[Prompt] Templates (", config.PromptTemplatesDir, ") error: ", err)
+			return false
+		}
+		for _, filename := range files {
+			bytes, err := os.ReadFile(filename)
+			if err != nil {
+				utils.Logger.LogError("[Prompt] Read file ", filename, " error: ", err)
+				return false
+			}
+			var template PromptTemplate
+			if err = yaml.Unmarshal(bytes, &template); err != nil {
+				utils.Logger.LogError("[Prompt] Template ", filename, " format error: ", err)
+				return false
+			}
+			if len(strings.TrimSpace(template.Name)) == 0 {
+				template.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+			}
+			if !prompt_template_add(template, filename) {
+				return false
+			}
+		}
+	}
+
+	prompt_prefixes = map[string]string{}
+	for k, v := range config.PromptPrefixes {
+		var group = prompt_group(k)
+		if len(group) == 0 {
+			utils.Logger.LogError("[Prompt] Prefix group (", k, ") invalidate.")
+			return false
+		}
+		prompt_prefixes[group] = v
+	}
+
+	utils.Logger.Log("[Prompt] Templates (", len(prompt_templates), "), Prefixes (", len(prompt_prefixes), ")")
+	return true
+}
+
+// Templates of the administrators (prompt_template_<name>), names : prompt_templates (name -> update time)
+func db_prompt_template_id(name string) string {
+	return fmt.Sprintf("prompt_template_%s", name)
+}
+
+const DB_PROMPT_TEMPLATES_ID = "prompt_templates"
+
+// Prefixes of the administrators (group -> content), override the config prefixes
+const DB_PROMPT_PREFIXES_ID = "prompt_prefixes"
+
+func db_prompt_template_get(name string) *PromptTemplate {
+	var template PromptTemplate
+	if !database_redis.GetJson(db_prompt_template_id(name), &template, false) {
+		return nil
+	}
+	return &template
+}
+
+func db_prompt_template_save(template *PromptTemplate) bool {
+	template.UpdateTime = utils.DateFormat(time.Now(), 3)
+	if !database_redis.PushJson[PromptTemplate](db_prompt_template_id(template.Name), template, database_redis.KEEP_TIME, false) {
+		return false
+	}
+	return database_redis.PushFields(DB_PROMPT_TEMPLATES_ID, map[string]string{template.Name: template.UpdateTime})
+}
+
+func db_prompt_template_delete(name string) bool {
+	database_redis.DelFields(DB_PROMPT_TEMPLATES_ID, name)
+	return database_redis.DelWithKey(db_prompt_template_id(name))
+}
+
+// Template of the administrators, or of the config
+func Prompt_Template(name string) *PromptTemplate {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !prompt_name_check(name) {
+		return nil
+	}
+	if template := db_prompt_template_get(name); template != nil {
+		return template
+	}
+	return prompt_templates[name]
+}
+
+// All templates, the administrators templates override the config templates
+func Prompt_Templates() map[string]*PromptTemplate {
+	var list = map[string]*PromptTemplate{}
+	for k, v := range prompt_templates {
+		list[k] = v
+	}
+	if fields, ok := database_redis.GetFields(DB_PROMPT_TEMPLATES_ID); ok {
+		for name := range fields {
+			if template := db_prompt_template_get(name); template != nil {
+				list[name] = template
+			}
+		}
+	}
+	return list
+}
+
+// Prefixes per group, the administrators prefixes override the config prefixes (empty : removed)
+func Prompt_Prefixes() map[string]string {
+	var list = map[string]string{}
+	for k, v := range prompt_prefixes {
+		list[k] = v
+	}
+	if fields, ok := database_redis.GetFields(DB_PROMPT_PREFIXES_ID); ok {
+		for k, v := range fields {
+			list[k] = v
+		}
+	}
+	for k, v := range list {
+		if len(strings.TrimSpace(v)) == 0 {
+			delete(list, k)
+		}
+	}
+	return list
+}
+
+// Variables of the content, the request values are used first, then the defaults
+func (I *PromptTemplate) Render(values map[string]string) (string, *OpenAIError) {
+	var missing = []string{}
+	var text = prompt_variable_regex.ReplaceAllStringFunc(I.Content, func(match string) string {
+		var name = prompt_variable_regex.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		if value, ok := I.Variables[name]; ok {
+			return value
+		}
+		missing = append(missing, name)
+		return match
+	})
+	if len(missing) > 0 {
+		var err = NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "variables",
+			fmt.Sprintf("Missing variables of the prompt template '%s': %s", I.Name, strings.Join(missing, ", ")))
+		err.Code = "missing_variables"
+		return "", err
+	}
+	return text, nil
+}
+
+func openai_system_message(content string) map[string]any {
+	return map[string]any{
+		"role":    "system",
+		"content": content,
+	}
+}
+
+// Mandatory prefix of the user (all users, role, plan), empty : none
+func prompt_user_prefix(idx utils.TIDX, role string) string {
+	var prefixes = Prompt_Prefixes()
+	if len(prefixes) == 0 {
+		return ""
+	}
+	var groups = []string{PROMPT_GROUP_ALL, PROMPT_GROUP_ROLE + role}
+	if plan := UserPlan_Get(idx); plan != nil {
+		groups = append(groups, PROMPT_GROUP_PLAN+plan.Name)
+	}
+	var list = []string{}
+	for _, v := range groups {
+		if text, ok := prefixes[v]; ok {
+			list = append(list, strings.TrimSpace(text))
+		}
+	}
+	return strings.Join(list, "\n\n")
+}
+
+// System messages of the chat request : the prompt template (prompt_template, variables) is rendered,
+// the mandatory prefix of the user group is added first, the template fields are removed from the body
+func OpenAI_PromptApply(body map[string]any, idx utils.TIDX, role string, model_id string) *OpenAIError {
+	var system = []any{}
+	if prefix := prompt_user_prefix(idx, role); len(prefix) > 0 {
+		system = append(system, openai_system_message(prefix))
+	}
+
+	value, ok := body["prompt_template"]
+	delete(body, "prompt_template")
+	variables, _ := body["variables"].(map[string]any)
+	delete(body, "variables")
+	if ok && value != nil {
+		name, _ := value.(string)
+		var template = Prompt_Template(name)
+		if template == nil {
+			var err = NewOpenAIError(http.StatusNotFound, "invalid_request_error", "prompt_template",
+				fmt.Sprintf("The prompt template '%v' does not exist", value))
+			err.Code = "prompt_template_not_found"
+			return err
+		}
+
+		var values = map[string]string{
+			"date":  time.Now().Format("2006-01-02"),
+			"model": model_id,
+		}
+		for k, v := range variables {
+			switch v.(type) {
+			case string, float64, bool:
+				values[k] = fmt.Sprint(v)
+			default:
+				return NewOpenAIError(http.StatusBadRequest, "invalid_request_error", "variables",
+					fmt.Sprintf("'%v' is not of type 'string' - 'variables.%s'", v, k))
+			}
+		}
+		text, err := template.Render(values)
+		if err != nil {
+			return err
+		}
+		system = append(system, openai_system_message(text))
+	}
+
+	if len(system) == 0 {
+		return nil
+	}
+	messages, _ := body["messages"].([]any)
+	body["messages"] = append(system, messages...)
+	return nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPromptGroup(t *testing.T) {
+	var tests = map[string]string{
+		"*":             PROMPT_GROUP_ALL,
+		" Role:Member ": "role:member",
+		"role:unknown":  "",
+		"plan:pro":      "plan:pro",
+		"plan:":         "",
+		"member":        "",
+	}
+	for value, group := range tests {
+		if result := prompt_group(value); result != group {
+			t.Errorf("prompt_group(%q) = %q, want %q", value, result, group)
+		}
+	}
+}
+
+func TestPromptRender(t *testing.T) {
+	var template = PromptTemplate{
+		Name:      "translator",
+		Content:   "Translate into {{language}} ({{ level }}), {{language}}. {not} {{}}",
+		Variables: map[string]string{"language": "English", "level": "formal"},
+	}
+
+	var tests = []struct {
+		values map[string]string
+		text   string
+	}{
+		{nil, "Translate into English (formal), English. {not} {{}}"},
+		{map[string]string{"language": "French"}, "Translate into French (formal), French. {not} {{}}"},
+		{map[string]string{"level": ""}, "Translate into English (), English. {not} {{}}"},
+	}
+	for _, v := range tests {
+		text, err := template.Render(v.values)
+		if err != nil || text != v.text {
+			t.Errorf("Render(%v) = %q, %v, want %q", v.values, text, err, v.text)
+		}
+	}
+
+	template.Content = "{{a}} {{b}} {{language}}"
+	if _, err := template.Render(nil); err == nil || err.Code != "missing_variables" || err.Param != "variables" {
+		t.Errorf("Render(missing) = %v", err)
+	}
+}
+
+func TestPromptInit(t *testing.T) {
+	var dir = t.TempDir()
+	os.WriteFile(filepath.Join(dir, "summary.yaml"), []byte("content: \"Summarize in {{words}} words.\"\n"), 0644)
+	defer func() {
+		prompt_templates = map[string]*PromptTemplate{}
+		prompt_prefixes = map[string]string{}
+	}()
+
+	var config = Config{
+		PromptTemplates:    []PromptTemplate{{Name: "translator", Content: "Translate."}},
+		PromptTemplatesDir: dir,
+		PromptPrefixes:     map[string]string{"*": "Be polite.", "Role:Member": "Be brief."},
+	}
+	if !Prompt_Init(config) {
+		t.Fatal("Prompt_Init() failed")
+	}
+	if len(prompt_templates) != 2 || prompt_templates["summary"] == nil || prompt_templates["translator"] == nil {
+		t.Errorf("prompt_templates = %v", prompt_templates)
+	}
+	if prompt_prefixes["role:member"] != "Be brief." || prompt_prefixes["*"] != "Be polite." {
+		t.Errorf("prompt_prefixes = %v", prompt_prefixes)
+	}
+
+	config.PromptPrefixes = map[string]string{"members": "x"}
+	if Prompt_Init(config) {
+		t.Errorf("Prompt_Init(invalid group) succeeded")
+	}
+	config.PromptPrefixes = nil
+	config.PromptTemplates = []PromptTemplate{{Name: "Invalid name", Content: "x"}}
+	if Prompt_Init(config) {
+		t.Errorf("Prompt_Init(invalid name) succeeded")
+	}
+}
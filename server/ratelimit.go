@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	// Seconds
+	RATE_LIMIT_WINDOW = 60
+)
+
+// Rate limits (config.yaml : rate_limits) per route group, the longest path prefix is used
+// 0 : unlimited
+//
+//	rate_limits:
+//	  - path: "/server/login"
+//	    ip_limit: 10
+//	    window: 60
+//	  - path: "/server/v1/models"
+//	    ip_limit: 600
+//	  - path: "/server/v1/"
+//	    ip_limit: 300
+//	    idx_limit: 120
+type RateLimitConfig struct {
+	Path     string `yaml:"path" json:"path"`
+	IPLimit  int64  `yaml:"ip_limit" json:"ip_limit"`
+	IDXLimit int64  `yaml:"idx_limit" json:"idx_limit"`
+	Window   int    `yaml:"window" json:"window"`
+}
+
+type ratelimit_result struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Duration
+	Allowed   bool
+}
+
+func ratelimit_find(limits []RateLimitConfig, path string) *RateLimitConfig {
+	var limit *RateLimitConfig = nil
+	for i, v := range limits {
+		if strings.HasPrefix(path, v.Path) && (limit == nil || len(v.Path) > len(limit.Path)) {
+			limit = &limits[i]
+		}
+	}
+	return limit
+}
+
+// IDX of the authorization ("idx-token", API key or ?idx=&auth_token=), only the issued tokens are counted
+func ratelimit_idx(ctx *gin.Context) utils.TIDX {
+	var text = authorization_text(ctx.GetHeader("Authorization"))
+	if len(text) == 0 {
+		text = ctx.Query("idx") + "-" + ctx.Query("auth_token")
+	}
+	if IsAPIKey(text) {
+		if api_key := db_apikey_get(text); api_key != nil {
+			return api_key.IDX
+		}
+		return 0
+	}
+	if IsJWT(text) {
+		if !JWT_Enabled() {
+			return 0
+		}
+		if claims, err := JWT_Verify(text); err == nil {
+			return claims.IDX
+		}
+		return 0
+	}
+
+	var values = strings.SplitN(text, "-", 2)
+	if len(values) != 2 {
+		return 0
+	}
+	idx, err := strconv.ParseInt(strings.TrimSpace(values[0]), 10, 32)
+	if err != nil || !utils.CheckAccountIDX(utils.TIDX(idx), 6, 12) || !utils.CheckToken(strings.TrimSpace(values[1])) {
+		return 0
+	}
+
+	var db_id = fmt.Sprintf("auth_user_%d_%s", idx, strings.TrimSpace(values[1]))
+	if !database_redis.HasKey(db_id) {
+		return 0
+	}
+	return utils.TIDX(idx)
+}
+
+// Key of the client IP, X-Forwarded-For is used only from the trusted proxies
+func ratelimit_ip_id(limit *RateLimitConfig, ctx *gin.Context) string {
+	return fmt.Sprintf("ratelimit_ip_%s_%s", limit.Path, ctx.ClientIP())
+}
+
+func ratelimit_check(key string, limit int64, window time.Duration) *ratelimit_result {
+	allowed, count, reset, err := database_redis.SlidingWindow(key, limit, window)
+	if err != nil {
+		// Redis unavailable, the request is not limited
+		utils.Logger.LogWarning("[RateLimit] (", key, ") failure: ", err)
+		return nil
+	}
+	return &ratelimit_result{
+		Limit:     limit,
+		Remaining: int64(math.Max(0, float64(limit-count))),
+		Reset:     reset,
+		Allowed:   allowed,
+	}
+}
+
+// Sliding window limits per IP and per IDX, shared by all instances (Redis)
+func RateLimitHandler(limits []RateLimitConfig) gin.HandlerFunc {
+	for i, v := range limits {
+		if limits[i].Window <= 0 {
+			limits[i].Window = RATE_LIMIT_WINDOW
+		}
+		utils.Logger.Log("[RateLimit] (", v.Path, ") IP:", v.IPLimit, ", IDX:", v.IDXLimit, ", Window:", limits[i].Window, "s")
+	}
+
+	return func(ctx *gin.Context) {
+		if ctx.IsAborted() || ctx.Request.Method == http.MethodOptions {
+			ctx.Next()
+			return
+		}
+
+		var path = ctx.Request.URL.Path
+		var limit = ratelimit_find(limits, path)
+		if limit == nil {
+			ctx.Next()
+			return
+		}
+
+		var window = time.Duration(limit.Window) * time.Second
+		var results = []*ratelimit_result{}
+		if limit.IPLimit > 0 {
+			results = append(results, ratelimit_check(ratelimit_ip_id(limit, ctx), limit.IPLimit, window))
+		}
+		if limit.IDXLimit > 0 {
+			if idx := ratelimit_idx(ctx); idx > 0 {
+				var key = fmt.Sprintf("ratelimit_user_%s_%d", limit.Path, idx)
+				results = append(results, ratelimit_check(key, limit.IDXLimit, window))
+			}
+		}
+
+		// The most restrictive result
+		var result *ratelimit_result = nil
+		for _, v := range results {
+			if v == nil {
+				continue
+			}
+			if result == nil || (result.Allowed && !v.Allowed) ||
+				(result.Allowed == v.Allowed && v.Remaining < result.Remaining) {
+				result = v
+			}
+		}
+		if result == nil {
+			ctx.Next()
+			return
+		}
+
+		var reset = int64(math.Ceil(result.Reset.Seconds()))
+		ctx.Header("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		ctx.Header("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+
+		if !result.Allowed {
+			ctx.Header("Retry-After", strconv.FormatInt(reset, 10))
+			utils.Logger.LogWarning("[RateLimit] (", path, ", ", ctx.ClientIP(), ") limit reached, retry after ", reset, "s")
+
+			var message = fmt.Sprintf("Rate limit reached: Limit %d / %ds. Please try again in %ds.", result.Limit, limit.Window, reset)
+			if strings.HasPrefix(path, "/server/v1/") {
+				var err = NewOpenAIError(http.StatusTooManyRequests, "requests", "", message)
+				err.Code = "rate_limit_exceeded"
+				HandleResultOpenAIError(ctx, err)
+				ctx.Abort()
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error_code":    -429,
+				"error_message": message,
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
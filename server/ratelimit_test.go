@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitFind(t *testing.T) {
+	var limits = []RateLimitConfig{
+		{Path: "/server/v1/", IPLimit: 300},
+		{Path: "/server/login", IPLimit: 10},
+		{Path: "/server/v1/models", IPLimit: 600},
+	}
+	var tests = []struct {
+		path  string
+		limit string
+	}{
+		{"/server/login", "/server/login"},
+		{"/server/v1/chat/completions", "/server/v1/"},
+		// The longest prefix
+		{"/server/v1/models", "/server/v1/models"},
+		{"/server/v1/models/gpt-4", "/server/v1/models"},
+		{"/server/user", ""},
+	}
+	for _, v := range tests {
+		var limit = ratelimit_find(limits, v.path)
+		var path = ""
+		if limit != nil {
+			path = limit.Path
+		}
+		if path != v.limit {
+			t.Errorf("ratelimit_find(%q) = %q, want %q", v.path, path, v.limit)
+		}
+	}
+}
+
+// The requests without a limit are not counted (Redis is not used)
+func TestRateLimitHandler(t *testing.T) {
+	var limits = []RateLimitConfig{{Path: "/server/login", IPLimit: 1}}
+	var handler = RateLimitHandler(limits)
+	if limits[0].Window != RATE_LIMIT_WINDOW {
+		t.Errorf("Window = %d, want %d", limits[0].Window, RATE_LIMIT_WINDOW)
+	}
+
+	var tests = []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/server/user"},
+		{http.MethodOptions, "/server/login"},
+	}
+	for _, v := range tests {
+		var recorder = httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest(v.method, v.path, nil)
+		handler(ctx)
+		if ctx.IsAborted() || len(recorder.Header().Get("X-RateLimit-Limit")) > 0 {
+			t.Errorf("%s %s is limited", v.method, v.path)
+		}
+	}
+}
+
+// X-Forwarded-For of the untrusted clients does not change the key
+func TestRateLimitIP(t *testing.T) {
+	var limit = &RateLimitConfig{Path: "/server/login"}
+	var tests = []struct {
+		name    string
+		proxies []string
+		remote  string
+		headers map[string]string
+		key     string
+	}{
+		{"remote address", nil, "192.0.2.10:5000", nil, "ratelimit_ip_/server/login_192.0.2.10"},
+		{"spoofed", nil, "192.0.2.10:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"ratelimit_ip_/server/login_192.0.2.10"},
+		{"spoofed real ip", nil, "192.0.2.10:5000", map[string]string{"X-Real-IP": "198.51.100.2"},
+			"ratelimit_ip_/server/login_192.0.2.10"},
+		{"untrusted proxy", []string{"192.0.2.1"}, "192.0.2.10:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"ratelimit_ip_/server/login_192.0.2.10"},
+		{"trusted proxy", []string{"192.0.2.0/24"}, "192.0.2.10:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"ratelimit_ip_/server/login_198.51.100.1"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, v := range tests {
+		var router = new_router(Config{TrustedProxies: v.proxies})
+		if router == nil {
+			t.Fatalf("%s: new_router() failed", v.name)
+		}
+		var key = ""
+		router.POST("/server/login", func(ctx *gin.Context) {
+			key = ratelimit_ip_id(limit, ctx)
+		})
+
+		var request = httptest.NewRequest(http.MethodPost, "/server/login", nil)
+		request.RemoteAddr = v.remote
+		for k, value := range v.headers {
+			request.Header.Set(k, value)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), request)
+		if key != v.key {
+			t.Errorf("%s: ratelimit_ip_id() = %q, want %q", v.name, key, v.key)
+		}
+	}
+
+	if new_router(Config{TrustedProxies: []string{"not an ip"}}) != nil {
+		t.Errorf("new_router(invalid proxies) succeeded")
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"golang.org/x/exp/slices"
+	"mcmcx.com/gpt-server/utils"
+)
+
+// Roles of the users (HandlerOptions.RequiredRoles), the administrators have all roles
+//
+//	admin    : management routes (/server/admin/*)
+//	member   : default role of the users
+//	readonly : models, usage and account, the model requests are refused
+//	service  : machine accounts (API keys)
+const (
+	ROLE_ADMIN    = "admin"
+	ROLE_MEMBER   = "member"
+	ROLE_READONLY = "readonly"
+	ROLE_SERVICE  = "service"
+)
+
+var USER_ROLES = []string{ROLE_ADMIN, ROLE_MEMBER, ROLE_READONLY, ROLE_SERVICE}
+
+// Scopes of the roles (HandlerOptions.RequiredScopes), the roles not in the list have all scopes
+var user_role_scopes = map[string][]string{
+	ROLE_READONLY: {SCOPE_MODELS, SCOPE_USAGE, SCOPE_ACCOUNT},
+}
+
+func IsUserRole(role string) bool {
+	return slices.Contains(USER_ROLES, role)
+}
+
+// Role of the user (admins : config without an account)
+func user_role(idx utils.TIDX) string {
+	var user = db_user_get(idx)
+	if user != nil {
+		return user.Role
+	}
+	if slices.Contains(server_config.Admins, idx) {
+		return ROLE_ADMIN
+	}
+	return ROLE_MEMBER
+}
+
+func user_role_check(role string, roles []string) bool {
+	return role == ROLE_ADMIN || slices.Contains(roles, role)
+}
+
+func user_role_has_scope(role string, scope string) bool {
+	scopes, ok := user_role_scopes[role]
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes, scope)
+}
@@ -51,7 +51,7 @@ func AllowDomainsHandler(domains []string) gin.HandlerFunc {
 
 		//Response Headers
 		ctx.Header("Access-Control-Allow-Headers", "accept,authorization,content-type,content-encoding,cache-control,transfer-encoding")
-		ctx.Header("Access-Control-Expose-Headers", "authorization,content-type,content-encoding,cache-control,transfer-encoding")
+		ctx.Header("Access-Control-Expose-Headers", "authorization,content-type,content-encoding,cache-control,transfer-encoding,x-model-used,x-ratelimit-limit,x-ratelimit-remaining,x-ratelimit-reset,retry-after,x-conversation-id,x-context-trimmed,x-cache")
 		ctx.Header("Access-Control-Allow-Credentials", "true")
 		if allow {
 			ctx.Header("Access-Control-Allow-Origin", origin)
@@ -85,7 +85,10 @@ func InitServer(config Config, mode string) *Server {
 	gin.SetMode(mode)
 
 	//
-	router := gin.Default()
+	router := new_router(config)
+	if router == nil {
+		return nil
+	}
 
 	if config.MemoryMax == 0 {
 		config.MemoryMax = 32
@@ -143,6 +146,9 @@ func InitServer(config Config, mode string) *Server {
 	if config.AllowDomains {
 		router.Use(AllowDomainsHandler(config.AllowDomainsList))
 	}
+	if len(config.RateLimits) > 0 {
+		router.Use(RateLimitHandler(config.RateLimits))
+	}
 
 	//
 	router.Use(ExceptionHandler())
@@ -158,6 +164,16 @@ func InitServer(config Config, mode string) *Server {
 	return &server
 }
 
+// X-Forwarded-For (ClientIP) is used only from the trusted proxies (trusted_proxies, default: none)
+func new_router(config Config) *gin.Engine {
+	router := gin.Default()
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		utils.Logger.LogError(LOG_HTTP_PREFIX, "Trusted proxies error: ", err)
+		return nil
+	}
+	return router
+}
+
 func (I Server) StartHTTPServer() bool {
 	var port = -1
 	if I.port > 0 {
@@ -222,12 +238,43 @@ func register_handlers(router *gin.Engine) bool {
 	router.Any("/server/ping", HandlePing)
 	router.Any("/server/auth", HandleUserAuth)
 	router.Any("/server/login", HandleUserLogin)
+	router.Any("/server/logout", HandleUserLogout)
+	router.Any("/server/token/refresh", HandleUserTokenRefresh)
+	router.GET("/server/.well-known/jwks.json", HandleUserJWKS)
+	router.GET("/server/oidc/login", HandleOIDCLogin)
+	router.GET("/server/oidc/callback", HandleOIDCCallback)
+	router.POST("/server/2fa/setup", HandleUser2FASetup)
+	router.POST("/server/2fa/verify", HandleUser2FAVerify)
+	router.POST("/server/2fa/recovery", HandleUser2FARecovery)
+	router.POST("/server/2fa/disable", HandleUser2FADisable)
+	router.GET("/server/sessions", HandleUserSessions)
+	router.DELETE("/server/sessions/:device", HandleUserSessionDelete)
+	router.Any("/server/apikeys", HandleUserAPIKeys)
+	router.DELETE("/server/apikeys/:id", HandleUserAPIKeyDelete)
+	router.POST("/server/register", HandleUserRegister)
+	router.POST("/server/password", HandleUserPassword)
+	router.Any("/server/account", HandleUserAccount)
+	router.GET("/server/usage", HandleUserUsage)
+	router.GET("/server/conversations", HandleUserConversations)
+	router.Any("/server/conversations/:id", HandleUserConversation)
+
+	// Administrator (RequiredRoles : admin)
+	admin := router.Group("/server/admin")
+	admin.GET("/upstreams", HandleAdminUpstreams)
+	admin.Any("/plans", HandleAdminPlans)
+	admin.Any("/users", HandleAdminUsers)
+	admin.Any("/prompts", HandleAdminPrompts)
 
 	// OpenAI API
 	//router.Any("/api/v1/models", HandleOpenAIModels)
 	//router.POST("/api/v1/chat/completions", HandleOpenAICompletions)
 	router.Any("/server/v1/models", HandleOpenAIModels)
 	router.POST("/server/v1/chat/completions", HandleOpenAICompletions)
+	router.POST("/server/v1/embeddings", HandleOpenAIEmbeddings)
+	router.POST("/server/v1/images/generations", HandleOpenAIImages)
+	router.POST("/server/v1/audio/transcriptions", HandleOpenAITranscriptions)
+	router.POST("/server/v1/audio/speech", HandleOpenAISpeech)
+	router.POST("/server/v1/tokenize", HandleOpenAITokenize)
 
 	//
 	return true
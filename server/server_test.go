@@ -0,0 +1,13 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"mcmcx.com/gpt-server/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.NewLogger().Init()
+	os.Exit(m.Run())
+}
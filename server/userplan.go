@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+// Plans (config.yaml : user_plans), 0 or empty : unlimited
+//
+//	user_default_plan: "free"
+//	user_plans:
+//	  - name: "free"
+//	    daily_tokens: 20000
+//	    monthly_tokens: 300000
+//	    models: ["gpt-3.5-turbo*"]
+//	    requests_per_minute: 10
+//	  - name: "pro"
+//	    monthly_tokens: 5000000
+//	    requests_per_minute: 60
+type UserPlan struct {
+	Name          string `yaml:"name" json:"name"`
+	DailyTokens   int64  `yaml:"daily_tokens" json:"daily_tokens"`
+	MonthlyTokens int64  `yaml:"monthly_tokens" json:"monthly_tokens"`
+	// Model ids, a trailing '*' matches by prefix
+	Models            []string `yaml:"models" json:"models"`
+	RequestsPerMinute int64    `yaml:"requests_per_minute" json:"requests_per_minute"`
+}
+
+// Plan of the user (plan_user_<idx>)
+type DBUserPlan struct {
+	IDX        utils.TIDX `json:"idx"`
+	Plan       string     `json:"plan"`
+	UpdateTime string     `json:"update_time"`
+}
+
+var user_plans map[string]*UserPlan = map[string]*UserPlan{}
+var user_default_plan string = ""
+
+func UserPlan_Init(plans []UserPlan, default_plan string) bool {
+	user_plans = map[string]*UserPlan{}
+	for _, v := range plans {
+		var plan = v
+		plan.Name = strings.ToLower(strings.TrimSpace(plan.Name))
+		if len(plan.Name) == 0 {
+			utils.Logger.LogError("[Plan] Plan name is empty.")
+			return false
+		}
+		for i, m := range plan.Models {
+			plan.Models[i] = strings.ToLower(strings.TrimSpace(m))
+		}
+		user_plans[plan.Name] = &plan
+	}
+
+	user_default_plan = strings.ToLower(strings.TrimSpace(default_plan))
+	if len(user_default_plan) > 0 && user_plans[user_default_plan] == nil {
+		utils.Logger.LogError("[Plan] Default plan (", user_default_plan, ") not found.")
+		return false
+	}
+	return true
+}
+
+func UserPlan_Find(name string) *UserPlan {
+	return user_plans[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// Plan of the user, or the default plan (nil : unlimited)
+func UserPlan_Get(idx utils.TIDX) *UserPlan {
+	var db_plan DBUserPlan
+	if database_redis.GetJson(db_plan_id(idx), &db_plan, false) {
+		if plan := UserPlan_Find(db_plan.Plan); plan != nil {
+			return plan
+		}
+	}
+	return UserPlan_Find(user_default_plan)
+}
+
+func UserPlan_Set(idx utils.TIDX, name string) bool {
+	var db_plan = DBUserPlan{
+		IDX:        idx,
+		Plan:       strings.ToLower(strings.TrimSpace(name)),
+		UpdateTime: utils.DateFormat(time.Now(), 3),
+	}
+	return database_redis.PushJson[DBUserPlan](db_plan_id(idx), &db_plan, database_redis.KEEP_TIME, false)
+}
+
+func db_plan_id(idx utils.TIDX) string {
+	return fmt.Sprintf("plan_user_%d", idx)
+}
+
+func (I *UserPlan) AllowModel(model_id string) bool {
+	if len(I.Models) == 0 {
+		return true
+	}
+	for _, v := range I.Models {
+		if v == "*" || model_match(v, model_id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens (prompt + completion) used by the user, date : yyyymmdd or yyyymm
+func user_usage_tokens(idx utils.TIDX, date string) int64 {
+	var tokens int64 = 0
+	for _, v := range OpenAI_UsageLoad(idx, date) {
+		tokens += v.TotalTokens
+	}
+	return tokens
+}
+
+// Allowed models, requests per minute, daily and monthly tokens
+func (I *UserPlan) Check(idx utils.TIDX, model_id string) *OpenAIError {
+	var now = time.Now()
+	if len(model_id) > 0 && !I.AllowModel(model_id) {
+		var err = NewOpenAIError(http.StatusForbidden, "invalid_request_error", "model",
+			fmt.Sprintf("The model '%s' is not available on your plan (%s).", model_id, I.Name))
+		err.Code = "model_not_allowed"
+		return err
+	}
+
+	if I.RequestsPerMinute > 0 {
+		var db_id = fmt.Sprintf("rpm_user_%d_%d", idx, now.Unix()/60)
+		count, ok := database_redis.IncrNumber(db_id, 120)
+		if ok && count > I.RequestsPerMinute {
+			var err = NewOpenAIError(http.StatusTooManyRequests, "requests", "",
+				fmt.Sprintf("Rate limit reached for requests on your plan (%s): Limit %d / min. Please try again in %ds.",
+					I.Name, I.RequestsPerMinute, 60-now.Second()))
+			err.Code = "rate_limit_exceeded"
+			return err
+		}
+	}
+
+	if I.DailyTokens > 0 && user_usage_tokens(idx, now.Format("20060102")) >= I.DailyTokens {
+		var err = NewOpenAIError(http.StatusTooManyRequests, "insufficient_quota", "",
+			fmt.Sprintf("You exceeded your daily quota of %d tokens on your plan (%s).", I.DailyTokens, I.Name))
+		err.Code = "insufficient_quota"
+		return err
+	}
+	if I.MonthlyTokens > 0 && user_usage_tokens(idx, now.Format("200601")) >= I.MonthlyTokens {
+		var err = NewOpenAIError(http.StatusTooManyRequests, "insufficient_quota", "",
+			fmt.Sprintf("You exceeded your monthly quota of %d tokens on your plan (%s).", I.MonthlyTokens, I.Name))
+		err.Code = "insufficient_quota"
+		return err
+	}
+	return nil
+}
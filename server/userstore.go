@@ -0,0 +1,278 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database_redis "mcmcx.com/gpt-server/database/redis"
+	"mcmcx.com/gpt-server/utils"
+)
+
+const (
+	USER_STATUS_ACTIVE   = "active"
+	USER_STATUS_DISABLED = "disabled"
+	// Failed login attempts, lockout (seconds)
+	LOGIN_MAX_FAILURES    = 5
+	LOGIN_MAX_FAILURES_IP = 20
+	LOGIN_LOCKOUT         = 900
+)
+
+// Accounts (config.yaml : users), added when the account does not exist
+// The password hash is created by : gpt-server passwd
+//
+//	users:
+//	  - idx: 123456
+//	    name: "admin"
+//	    password: "$argon2id$v=19$m=65536,t=3,p=4$..."
+//	    role: "admin"
+type UserConfig struct {
+	IDX      utils.TIDX `yaml:"idx" json:"idx"`
+	Name     string     `yaml:"name" json:"name"`
+	Password string     `yaml:"password" json:"password"`
+	// Default: member
+	Role string `yaml:"role" json:"role"`
+}
+
+// User store (Redis hash user_<idx>), the name index : user_name_<name>
+type DBUserData struct {
+	IDX  utils.TIDX
+	Name string
+	// argon2id or bcrypt hash
+	Password string
+	Status   string
+	// Default: member (the accounts without a role)
+	Role       string
+	CreateTime string
+	UpdateTime string
+	// Two-factor authentication (TOTP), the pending secret is enabled by /server/2fa/verify
+	TOTPSecret  string
+	TOTPPending string
+	// Last used step of the codes (replay)
+	TOTPStep int64
+	// SHA256 of the recovery codes, the codes are used once
+	RecoveryCodes []string
+}
+
+// Hash of the dummy password, the unknown accounts are verified in the same time
+var user_dummy_password string = ""
+var user_dummy_once sync.Once
+
+// admins : the admin role is given to the accounts (config : admins)
+func UserStore_Init(users []UserConfig, admins []utils.TIDX) bool {
+	for _, v := range users {
+		v.Name = strings.TrimSpace(v.Name)
+		v.Role = strings.ToLower(strings.TrimSpace(v.Role))
+		if len(v.Role) == 0 {
+			v.Role = ROLE_MEMBER
+		}
+		if !utils.CheckAccountIDX(v.IDX, 6, 12) || len(v.Password) == 0 || !IsUserRole(v.Role) {
+			utils.Logger.LogError("[User] Account (", v.IDX, ", ", v.Name, ") config error.")
+			return false
+		}
+		if db_user_get(v.IDX) != nil {
+			continue
+		}
+
+		var user = &DBUserData{
+			IDX:        v.IDX,
+			Name:       v.Name,
+			Password:   v.Password,
+			Status:     USER_STATUS_ACTIVE,
+			Role:       v.Role,
+			CreateTime: utils.DateFormat(time.Now(), 3),
+		}
+		if !db_user_save(user) {
+			utils.Logger.LogError("[User] Account (", v.IDX, ", ", v.Name, ") saving failure.")
+			return false
+		}
+		utils.Logger.Log("[User] Account (", v.IDX, ", ", v.Name, ") added.")
+	}
+
+	for _, idx := range admins {
+		var user = db_user_get(idx)
+		if user == nil || user.Role == ROLE_ADMIN {
+			continue
+		}
+		user.Role = ROLE_ADMIN
+		if !db_user_save(user) {
+			utils.Logger.LogError("[User] Account (", idx, ") role saving failure.")
+			return false
+		}
+		utils.Logger.Log("[User] Account (", idx, ", ", user.Name, ") role : ", user.Role)
+	}
+	return true
+}
+
+func db_user_id(idx utils.TIDX) string {
+	return fmt.Sprintf("user_%d", idx)
+}
+
+func db_user_name_id(name string) string {
+	return fmt.Sprintf("user_name_%s", strings.ToLower(strings.TrimSpace(name)))
+}
+
+func db_user_get(idx utils.TIDX) *DBUserData {
+	fields, ok := database_redis.GetFields(db_user_id(idx))
+	if !ok || len(fields) == 0 {
+		return nil
+	}
+
+	value, err := strconv.ParseInt(fields["idx"], 10, 64)
+	if err != nil || utils.TIDX(value) != idx {
+		return nil
+	}
+	var user = &DBUserData{
+		IDX:           idx,
+		Name:          fields["name"],
+		Password:      fields["password"],
+		Status:        fields["status"],
+		Role:          fields["role"],
+		CreateTime:    fields["create_time"],
+		UpdateTime:    fields["update_time"],
+		TOTPSecret:    fields["totp_secret"],
+		TOTPPending:   fields["totp_pending"],
+		RecoveryCodes: []string{},
+	}
+	if len(user.Role) == 0 {
+		user.Role = ROLE_MEMBER
+	}
+	user.TOTPStep, _ = strconv.ParseInt(fields["totp_step"], 10, 64)
+	if len(fields["recovery_codes"]) > 0 {
+		user.RecoveryCodes = strings.Split(fields["recovery_codes"], ",")
+	}
+	return user
+}
+
+// IDX of the account name
+func db_user_find(name string) utils.TIDX {
+	if len(strings.TrimSpace(name)) == 0 {
+		return 0
+	}
+	value, ok := database_redis.GetString(db_user_name_id(name))
+	if !ok {
+		return 0
+	}
+	idx, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return utils.TIDX(idx)
+}
+
+func db_user_save(user *DBUserData) bool {
+	user.UpdateTime = utils.DateFormat(time.Now(), 3)
+	if !database_redis.PushFields(db_user_id(user.IDX), map[string]string{
+		"idx":         fmt.Sprintf("%d", user.IDX),
+		"name":        user.Name,
+		"password":    user.Password,
+		"status":      user.Status,
+		"role":        user.Role,
+		"create_time": user.CreateTime,
+		"update_time": user.UpdateTime,
+		// Two-factor authentication
+		"totp_secret":    user.TOTPSecret,
+		"totp_pending":   user.TOTPPending,
+		"totp_step":      fmt.Sprintf("%d", user.TOTPStep),
+		"recovery_codes": strings.Join(user.RecoveryCodes, ","),
+	}) {
+		return false
+	}
+	if len(user.Name) > 0 {
+		return database_redis.PushString(db_user_name_id(user.Name), fmt.Sprintf("%d", user.IDX), database_redis.KEEP_TIME)
+	}
+	return true
+}
+
+// Password of the account, the unknown and disabled accounts are failed
+func (I *DBUserData) Verify(password string) bool {
+	if I == nil {
+		user_dummy_once.Do(func() {
+			user_dummy_password = utils.PasswordHash(utils.GenerateCode(2))
+		})
+		utils.PasswordVerify(password, user_dummy_password)
+		return false
+	}
+	if !utils.PasswordVerify(password, I.Password) {
+		return false
+	}
+	return I.Status != USER_STATUS_DISABLED
+}
+
+// Failed login attempts per IDX and per IP (login_failed_idx_<idx>, login_failed_ip_<ip>)
+func login_failed_ids(idx utils.TIDX, ip string) (string, string) {
+	return fmt.Sprintf("login_failed_idx_%d", idx), fmt.Sprintf("login_failed_ip_%s", ip)
+}
+
+func login_max_failures() (int64, int64, float32) {
+	var max_failures = int64(server_config.LoginMaxFailures)
+	if max_failures <= 0 {
+		max_failures = LOGIN_MAX_FAILURES
+	}
+	var max_failures_ip = int64(server_config.LoginMaxFailuresIP)
+	if max_failures_ip <= 0 {
+		max_failures_ip = LOGIN_MAX_FAILURES_IP
+	}
+	var lockout = float32(server_config.LoginLockout)
+	if lockout <= 0 {
+		lockout = LOGIN_LOCKOUT
+	}
+	return max_failures, max_failures_ip, lockout
+}
+
+func login_locked(idx utils.TIDX, ip string) bool {
+	max_failures, max_failures_ip, _ := login_max_failures()
+	db_idx_id, db_ip_id := login_failed_ids(idx, ip)
+	if login_failures(db_idx_id) >= max_failures || login_failures(db_ip_id) >= max_failures_ip {
+		return true
+	}
+	return false
+}
+
+func login_failures(db_id string) int64 {
+	value, ok := database_redis.GetString(db_id)
+	if !ok {
+		return 0
+	}
+	count, _ := strconv.ParseInt(value, 10, 64)
+	return count
+}
+
+// The lockout starts from the first failure
+func login_failed(idx utils.TIDX, ip string) {
+	_, _, lockout := login_max_failures()
+	db_idx_id, db_ip_id := login_failed_ids(idx, ip)
+	count, _ := database_redis.IncrNumber(db_idx_id, lockout)
+	database_redis.IncrNumber(db_ip_id, lockout)
+	utils.Logger.LogWarning("[Login] IDX:", idx, " failed (", count, ") IPAddress (", ip, ")")
+}
+
+func login_succeeded(idx utils.TIDX) {
+	db_idx_id, _ := login_failed_ids(idx, "")
+	database_redis.DelWithKey(db_idx_id)
+}
+
+func db_user_delete(user *DBUserData) bool {
+	if len(user.Name) > 0 {
+		database_redis.DelWithKey(db_user_name_id(user.Name))
+	}
+	database_redis.DelWithKey(db_plan_id(user.IDX))
+	return database_redis.DelWithKey(db_user_id(user.IDX))
+}
+
+// Accounts of the store (user_<idx>)
+func db_user_list() []*DBUserData {
+	var list = []*DBUserData{}
+	for _, k := range database_redis.Keys("user_[0-9]*") {
+		idx, err := strconv.ParseInt(strings.TrimPrefix(k, "user_"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if user := db_user_get(utils.TIDX(idx)); user != nil {
+			list = append(list, user)
+		}
+	}
+	return list
+}
@@ -0,0 +1,198 @@
+package main
+
+// Local OpenID Connect provider of the testing (config.yaml : oidc)
+// The logins are approved without a password, the ID tokens are signed (RS256)
+//
+//	go run ./test/oidc -addr 127.0.0.1:9400 -sub user-1 -email user@example.com
+//	curl -k -L -b cookies.txt -c cookies.txt "https://127.0.0.1:9443/server/oidc/login?device_uid=test"
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mcmcx.com/gpt-server/utils"
+)
+
+type oidc_code struct {
+	ClientID      string
+	RedirectUrl   string
+	Nonce         string
+	CodeChallenge string
+	Expires       time.Time
+}
+
+var issuer string
+var subject string
+var email string
+var client_secret string
+
+var private_key *rsa.PrivateKey
+var key_id = "test-key-1"
+
+var codes = map[string]*oidc_code{}
+var codes_lock sync.Mutex
+
+func write_json(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func encode(buffer []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
+
+func sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key_id})
+	payload, _ := json.Marshal(claims)
+	var text = encode(header) + "." + encode(payload)
+	var digest = sha256.Sum256([]byte(text))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, private_key, crypto.SHA256, digest[:])
+	if err != nil {
+		return ""
+	}
+	return text + "." + encode(signature)
+}
+
+func handle_discovery(w http.ResponseWriter, r *http.Request) {
+	write_json(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func handle_jwks(w http.ResponseWriter, r *http.Request) {
+	var public_key = private_key.PublicKey
+	write_json(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": key_id,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   encode(public_key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(public_key.E)).Bytes()),
+		}},
+	})
+}
+
+// The login is approved, redirect to the client with the code
+func handle_authorize(w http.ResponseWriter, r *http.Request) {
+	var query = r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" ||
+		len(query.Get("code_challenge")) == 0 || len(query.Get("redirect_uri")) == 0 {
+		write_json(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	var code = strings.ToLower(utils.GenerateToken()[0:32])
+	codes_lock.Lock()
+	codes[code] = &oidc_code{
+		ClientID:      query.Get("client_id"),
+		RedirectUrl:   query.Get("redirect_uri"),
+		Nonce:         query.Get("nonce"),
+		CodeChallenge: query.Get("code_challenge"),
+		Expires:       time.Now().Add(time.Minute),
+	}
+	codes_lock.Unlock()
+
+	var values = url.Values{}
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	utils.Logger.Log("[OIDC] Authorize client:", query.Get("client_id"), " code:", code)
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+values.Encode(), http.StatusFound)
+}
+
+// Code and PKCE verifier, the ID token is returned
+func handle_token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		write_json(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	codes_lock.Lock()
+	var data = codes[r.PostForm.Get("code")]
+	delete(codes, r.PostForm.Get("code"))
+	codes_lock.Unlock()
+
+	var challenge = sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if data == nil || time.Now().After(data.Expires) || encode(challenge[:]) != data.CodeChallenge ||
+		data.RedirectUrl != r.PostForm.Get("redirect_uri") {
+		write_json(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	client_id, secret, ok := r.BasicAuth()
+	if !ok {
+		client_id = r.PostForm.Get("client_id")
+	}
+	if client_id != data.ClientID || (len(client_secret) > 0 && secret != client_secret) {
+		write_json(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	var now = time.Now().Unix()
+	var id_token = sign(map[string]any{
+		"iss":                issuer,
+		"sub":                subject,
+		"aud":                data.ClientID,
+		"iat":                now,
+		"exp":                now + 300,
+		"nonce":              data.Nonce,
+		"email":              email,
+		"email_verified":     true,
+		"preferred_username": strings.Split(email, "@")[0],
+	})
+	utils.Logger.Log("[OIDC] Token client:", client_id, " sub:", subject)
+	write_json(w, http.StatusOK, map[string]any{
+		"access_token": strings.ToLower(utils.GenerateToken()),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     id_token,
+	})
+}
+
+func main() {
+	var address = flag.String("addr", "127.0.0.1:9400", "listen address")
+	flag.StringVar(&subject, "sub", "user-1", "subject of the ID tokens")
+	flag.StringVar(&email, "email", "user@example.com", "email of the ID tokens")
+	flag.StringVar(&client_secret, "secret", "", "client secret (empty : not checked)")
+	flag.Parse()
+
+	logger := utils.NewLogger()
+	logger.Init()
+
+	issuer = "http://" + *address
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		logger.LogError("[OIDC] Key error: ", err)
+		return
+	}
+	private_key = key
+
+	http.HandleFunc("/.well-known/openid-configuration", handle_discovery)
+	http.HandleFunc("/jwks", handle_jwks)
+	http.HandleFunc("/authorize", handle_authorize)
+	http.HandleFunc("/token", handle_token)
+
+	logger.Log("[OIDC] Issuer ", issuer)
+	if err := http.ListenAndServe(*address, nil); err != nil {
+		logger.LogError("[OIDC] Error: ", err)
+	}
+}
@@ -0,0 +1,446 @@
+package tokenizer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// cl100k_base (gpt-3.5-turbo, gpt-4, text-embedding-ada-002)
+// https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken
+const (
+	CL100K_BASE = "cl100k_base"
+	// Special tokens
+	CL100K_ENDOFTEXT   = 100257
+	CL100K_FIM_PREFIX  = 100258
+	CL100K_FIM_MIDDLE  = 100259
+	CL100K_FIM_SUFFIX  = 100260
+	CL100K_ENDOFPROMPT = 100276
+)
+
+type Encoding struct {
+	Name string
+	//
+	ranks   map[string]int
+	special map[string]int
+}
+
+// Vocabulary file (tiktoken format), each line : "<base64 token> <rank>"
+func NewEncodingFromFile(name string, filename string) (*Encoding, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var encoding = &Encoding{
+		Name:  name,
+		ranks: map[string]int{},
+		special: map[string]int{
+			"<|endoftext|>":   CL100K_ENDOFTEXT,
+			"<|fim_prefix|>":  CL100K_FIM_PREFIX,
+			"<|fim_middle|>":  CL100K_FIM_MIDDLE,
+			"<|fim_suffix|>":  CL100K_FIM_SUFFIX,
+			"<|endofprompt|>": CL100K_ENDOFPROMPT,
+		},
+	}
+
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var values = strings.Fields(line)
+		if len(values) != 2 {
+			return nil, errors.New("vocabulary format error: " + line)
+		}
+		token, err := base64.StdEncoding.DecodeString(values[0])
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, err
+		}
+		encoding.ranks[string(token)] = rank
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(encoding.ranks) == 0 {
+		return nil, errors.New("vocabulary is empty")
+	}
+	return encoding, nil
+}
+
+func (I *Encoding) Size() int {
+	return len(I.ranks) + len(I.special)
+}
+
+// Ordinary text, special tokens are encoded as text
+func (I *Encoding) Encode(text string) []int {
+	var tokens = []int{}
+	for _, piece := range Split(text) {
+		if rank, ok := I.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, I.merge([]byte(piece))...)
+	}
+	return tokens
+}
+
+// Text with special tokens ("<|endoftext|>" ...)
+func (I *Encoding) EncodeWithSpecial(text string) []int {
+	var tokens = []int{}
+	for len(text) > 0 {
+		var pos = -1
+		var name = ""
+		for k := range I.special {
+			var i = strings.Index(text, k)
+			if i >= 0 && (pos < 0 || i < pos) {
+				pos = i
+				name = k
+			}
+		}
+		if pos < 0 {
+			tokens = append(tokens, I.Encode(text)...)
+			break
+		}
+		tokens = append(tokens, I.Encode(text[0:pos])...)
+		tokens = append(tokens, I.special[name])
+		text = text[pos+len(name):]
+	}
+	return tokens
+}
+
+func (I *Encoding) Count(text string) int {
+	return len(I.Encode(text))
+}
+
+// Tokens of the text without the vocabulary, an upper estimate of cl100k_base :
+// every piece is at least one token, ASCII 4 bytes per token, a 2 bytes rune 1 token,
+// a 3 bytes rune (CJK ...) 2 tokens, a 4 bytes rune (emoji ...) 3 tokens
+func Estimate(text string) int {
+	var count float64 = 0
+	for _, piece := range Split(text) {
+		var tokens float64 = 0
+		for _, r := range piece {
+			switch utf8.RuneLen(r) {
+			case 1:
+				tokens += 0.25
+			case 2:
+				tokens += 1
+			case 3:
+				tokens += 2
+			default:
+				tokens += 3
+			}
+		}
+		count += math.Max(1, tokens)
+	}
+	return int(math.Ceil(count))
+}
+
+// Pieces up to this length (bytes) are merged by scanning the pairs,
+// the longer pieces by a priority queue (O(n log n))
+const MERGE_LINEAR_MAX = 64
+
+// Byte pair merge, the pair with the lowest rank is merged first (the leftmost of the equal ranks)
+func (I *Encoding) merge(piece []byte) []int {
+	if len(piece) <= MERGE_LINEAR_MAX {
+		return I.merge_linear(piece)
+	}
+	return I.merge_queue(piece)
+}
+
+// Parts of the merged piece, the bytes not in the vocabulary are tokens of every byte
+func (I *Encoding) merge_tokens(parts [][]byte) []int {
+	var tokens = make([]int, 0, len(parts))
+	for _, v := range parts {
+		rank, ok := I.ranks[string(v)]
+		if !ok {
+			for _, b := range v {
+				tokens = append(tokens, I.ranks[string([]byte{b})])
+			}
+			continue
+		}
+		tokens = append(tokens, rank)
+	}
+	return tokens
+}
+
+// Every pair is scanned after each merge, O(n^2) of the short pieces
+func (I *Encoding) merge_linear(piece []byte) []int {
+	var parts = make([][]byte, 0, len(piece))
+	for i := range piece {
+		parts = append(parts, piece[i:i+1])
+	}
+
+	for len(parts) > 1 {
+		var min_rank = math.MaxInt
+		var min_index = -1
+		for i := 0; i < len(parts)-1; i++ {
+			rank, ok := I.ranks[string(parts[i])+string(parts[i+1])]
+			if ok && rank < min_rank {
+				min_rank = rank
+				min_index = i
+			}
+		}
+		if min_index < 0 {
+			break
+		}
+
+		var merged = make([]byte, 0, len(parts[min_index])+len(parts[min_index+1]))
+		merged = append(merged, parts[min_index]...)
+		merged = append(merged, parts[min_index+1]...)
+		parts[min_index] = merged
+		parts = append(parts[0:min_index+1], parts[min_index+2:]...)
+	}
+	return I.merge_tokens(parts)
+}
+
+// Pair of the adjacent parts [start, end) and its rank
+type merge_pair struct {
+	rank  int
+	start int
+	end   int
+}
+
+type merge_pairs []merge_pair
+
+func (I merge_pairs) Len() int { return len(I) }
+func (I merge_pairs) Less(i, j int) bool {
+	return I[i].rank < I[j].rank || (I[i].rank == I[j].rank && I[i].start < I[j].start)
+}
+func (I merge_pairs) Swap(i, j int) { I[i], I[j] = I[j], I[i] }
+func (I *merge_pairs) Push(value any) {
+	*I = append(*I, value.(merge_pair))
+}
+func (I *merge_pairs) Pop() any {
+	var list = *I
+	var value = list[len(list)-1]
+	*I = list[0 : len(list)-1]
+	return value
+}
+
+// The same merges of merge_linear, the pairs are kept in a priority queue (rank, start),
+// the pairs changed by the previous merges are skipped when they are popped
+func (I *Encoding) merge_queue(piece []byte) []int {
+	var length = len(piece)
+	// ends[i] : end of the part starting at i, 0 : i is not the start of a part
+	var ends = make([]int, length)
+	var prevs = make([]int, length)
+	for i := range piece {
+		ends[i] = i + 1
+		prevs[i] = i - 1
+	}
+
+	var queue = &merge_pairs{}
+	var push = func(start int) {
+		var mid = ends[start]
+		if mid >= length {
+			return
+		}
+		if rank, ok := I.ranks[string(piece[start:ends[mid]])]; ok {
+			heap.Push(queue, merge_pair{rank: rank, start: start, end: ends[mid]})
+		}
+	}
+	for i := 0; i < length-1; i++ {
+		push(i)
+	}
+
+	for queue.Len() > 0 {
+		var pair = heap.Pop(queue).(merge_pair)
+		var mid = ends[pair.start]
+		if mid == 0 || mid >= length || ends[mid] != pair.end {
+			continue
+		}
+
+		ends[pair.start] = pair.end
+		ends[mid] = 0
+		if pair.end < length {
+			prevs[pair.end] = pair.start
+		}
+		push(pair.start)
+		if prevs[pair.start] >= 0 {
+			push(prevs[pair.start])
+		}
+	}
+
+	var parts = [][]byte{}
+	for i := 0; i < length; i = ends[i] {
+		parts = append(parts, piece[i:ends[i]])
+	}
+	return I.merge_tokens(parts)
+}
+
+// Pre-tokenizer of cl100k_base, RE2 has no look-ahead so the pattern is matched by hand:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func Split(text string) []string {
+	var pieces = []string{}
+	for len(text) > 0 {
+		var n = split_contraction(text)
+		if n == 0 {
+			n = split_letters(text)
+		}
+		if n == 0 {
+			n = split_numbers(text)
+		}
+		if n == 0 {
+			n = split_punctuation(text)
+		}
+		if n == 0 {
+			n = split_spaces(text)
+		}
+		if n == 0 {
+			// Not reachable, one character
+			_, n = utf8.DecodeRuneInString(text)
+		}
+		pieces = append(pieces, text[0:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+func is_letter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func is_number(r rune) bool {
+	return unicode.IsNumber(r)
+}
+
+func is_space(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+func is_newline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// (?i:'s|'t|'re|'ve|'m|'ll|'d)
+func split_contraction(text string) int {
+	if len(text) < 2 || text[0] != '\'' {
+		return 0
+	}
+	var lower = strings.ToLower(text[0:int(math.Min(3, float64(len(text))))])
+	for _, v := range []string{"'re", "'ve", "'ll"} {
+		if strings.HasPrefix(lower, v) {
+			return 3
+		}
+	}
+	for _, v := range []string{"'s", "'t", "'m", "'d"} {
+		if strings.HasPrefix(lower, v) {
+			return 2
+		}
+	}
+	return 0
+}
+
+// [^\r\n\p{L}\p{N}]?\p{L}+
+func split_letters(text string) int {
+	var pos = 0
+	r, n := utf8.DecodeRuneInString(text)
+	if !is_letter(r) {
+		if is_newline(r) || is_number(r) {
+			return 0
+		}
+		pos = n
+	}
+
+	var start = pos
+	for pos < len(text) {
+		r, n = utf8.DecodeRuneInString(text[pos:])
+		if !is_letter(r) {
+			break
+		}
+		pos += n
+	}
+	if pos == start {
+		return 0
+	}
+	return pos
+}
+
+// \p{N}{1,3}
+func split_numbers(text string) int {
+	var pos = 0
+	for i := 0; i < 3 && pos < len(text); i++ {
+		r, n := utf8.DecodeRuneInString(text[pos:])
+		if !is_number(r) {
+			break
+		}
+		pos += n
+	}
+	return pos
+}
+
+// ' ?[^\s\p{L}\p{N}]+[\r\n]*'
+func split_punctuation(text string) int {
+	var pos = 0
+	if text[0] == ' ' {
+		pos = 1
+	}
+
+	var start = pos
+	for pos < len(text) {
+		r, n := utf8.DecodeRuneInString(text[pos:])
+		if is_space(r) || is_letter(r) || is_number(r) {
+			break
+		}
+		pos += n
+	}
+	if pos == start {
+		return 0
+	}
+
+	for pos < len(text) && is_newline(rune(text[pos])) {
+		pos++
+	}
+	return pos
+}
+
+// \s*[\r\n]+|\s+(?!\S)|\s+
+func split_spaces(text string) int {
+	var pos = 0
+	var newline = -1
+	var last = 0
+	for pos < len(text) {
+		r, n := utf8.DecodeRuneInString(text[pos:])
+		if !is_space(r) {
+			break
+		}
+		if is_newline(r) {
+			newline = pos + n
+		}
+		last = pos
+		pos += n
+	}
+	if pos == 0 {
+		return 0
+	}
+
+	// \s*[\r\n]+
+	if newline > 0 {
+		return newline
+	}
+	// \s+(?!\S)
+	if pos == len(text) {
+		return pos
+	}
+	if last > 0 {
+		return last
+	}
+	// \s+
+	return pos
+}
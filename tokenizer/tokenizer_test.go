@@ -0,0 +1,193 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	var tests = []struct {
+		text   string
+		pieces []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"Hello, world!\n", []string{"Hello", ",", " world", "!\n"}},
+		// Contractions (case-insensitive)
+		{"I'm here", []string{"I", "'m", " here"}},
+		{"don't stop", []string{"don", "'t", " stop"}},
+		{"THEY'RE", []string{"THEY", "'RE"}},
+		{"we'll've", []string{"we", "'ll", "'ve"}},
+		// Digits, at most 3 per piece
+		{"12345", []string{"123", "45"}},
+		{" 42", []string{" ", "42"}},
+		{"x+=1", []string{"x", "+=", "1"}},
+		// Whitespace runs
+		{"hello   world", []string{"hello", "  ", " world"}},
+		{"end   ", []string{"end", "   "}},
+		{"a\n\nb", []string{"a", "\n\n", "b"}},
+		{"  \n x", []string{"  \n", " x"}},
+		{"\t\tx", []string{"\t", "\tx"}},
+		// Unicode letters
+		{"ça va?", []string{"ça", " va", "?"}},
+		{"お誕生日おめでとう", []string{"お誕生日おめでとう"}},
+		{"", []string{}},
+	}
+
+	for _, v := range tests {
+		var pieces = Split(v.text)
+		if !reflect.DeepEqual(pieces, v.pieces) {
+			t.Errorf("Split(%q) = %q, want %q", v.text, pieces, v.pieces)
+		}
+	}
+}
+
+// Vocabulary file of the single bytes (rank = byte) and the merges of the tokens
+func write_vocabulary(t *testing.T, merges []string) string {
+	var lines = []string{}
+	for i := 0; i < 256; i++ {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i))
+	}
+	for i, v := range merges {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte(v)), 256+i))
+	}
+	var filename = filepath.Join(t.TempDir(), "test.tiktoken")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestEncodeVocabulary(t *testing.T) {
+	encoding, err := NewEncodingFromFile("test", write_vocabulary(t, []string{"ab", "abc", "bc", " a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		text   string
+		tokens []int
+	}{
+		{"abc", []int{257}},
+		// "ab" (256) is merged before "bc" (258)
+		{"abcbc", []int{257, 258}},
+		// "ab" (256) is merged before " a" (259)
+		{" abc", []int{' ', 257}},
+		{" acd", []int{259, 'c', 'd'}},
+		{"xyz", []int{'x', 'y', 'z'}},
+		{"ab<|endoftext|>", []int{256, '<', '|', 'e', 'n', 'd', 'o', 'f', 't', 'e', 'x', 't', '|', '>'}},
+	}
+	for _, v := range tests {
+		if tokens := encoding.Encode(v.text); !reflect.DeepEqual(tokens, v.tokens) {
+			t.Errorf("Encode(%q) = %v, want %v", v.text, tokens, v.tokens)
+		}
+	}
+
+	if tokens := encoding.EncodeWithSpecial("ab<|endoftext|>abc"); !reflect.DeepEqual(tokens, []int{256, CL100K_ENDOFTEXT, 257}) {
+		t.Errorf("EncodeWithSpecial = %v", tokens)
+	}
+	if count := encoding.Count("abcbc xyz"); count != 6 {
+		t.Errorf("Count = %d, want 6", count)
+	}
+}
+
+// The priority queue merge is the same of the linear merge
+func TestMergeQueue(t *testing.T) {
+	var random = rand.New(rand.NewSource(1))
+	var alphabet = "abcd "
+	var merges = []string{}
+	var exists = map[string]bool{}
+	var tokens = []string{"a", "b", "c", "d", " "}
+	for len(merges) < 200 {
+		var v = tokens[random.Intn(len(tokens))] + tokens[random.Intn(len(tokens))]
+		if exists[v] || len(v) > 8 {
+			continue
+		}
+		exists[v] = true
+		merges = append(merges, v)
+		tokens = append(tokens, v)
+	}
+
+	encoding, err := NewEncodingFromFile("test", write_vocabulary(t, merges))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		var piece = make([]byte, 1+random.Intn(300))
+		for j := range piece {
+			piece[j] = alphabet[random.Intn(len(alphabet))]
+		}
+		var linear = encoding.merge_linear(piece)
+		var queue = encoding.merge_queue(piece)
+		if !reflect.DeepEqual(linear, queue) {
+			t.Fatalf("merge_queue(%q) = %v, want %v", piece, queue, linear)
+		}
+	}
+}
+
+// The estimate is not less than the tokens of cl100k_base
+func TestEstimate(t *testing.T) {
+	var tests = []struct {
+		text   string
+		tokens int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"tiktoken is great!", 6},
+		{"antidisestablishmentarianism", 6},
+		{"2 + 2 = 4", 7},
+		{"お誕生日おめでとう", 9},
+		{"Привет, мир!", 4},
+		{"👋🌍", 4},
+	}
+	for _, v := range tests {
+		var count = Estimate(v.text)
+		if count < v.tokens || (v.tokens > 0 && count > v.tokens*3) {
+			t.Errorf("Estimate(%q) = %d, cl100k_base : %d", v.text, count, v.tokens)
+		}
+	}
+}
+
+// Known encodings of cl100k_base (tiktoken), the vocabulary file is not a part of the repository :
+// CL100K_BASE_FILE=/path/to/cl100k_base.tiktoken go test ./tokenizer
+func TestEncodeCL100K(t *testing.T) {
+	var filename = os.Getenv("CL100K_BASE_FILE")
+	if len(filename) == 0 {
+		filename = filepath.Join("..", "bin", "cl100k_base.tiktoken")
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Skip("cl100k_base.tiktoken not found")
+	}
+	encoding, err := NewEncodingFromFile(CL100K_BASE, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		text   string
+		tokens []int
+	}{
+		{"hello world", []int{15339, 1917}},
+		{"tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{"antidisestablishmentarianism", []int{519, 85342, 34500, 479, 8997, 2191}},
+		{"2 + 2 = 4", []int{17, 489, 220, 17, 284, 220, 19}},
+		{"お誕生日おめでとう", []int{33334, 45918, 243, 21990, 9080, 33334, 62004, 16556, 78699}},
+	}
+	for _, v := range tests {
+		if tokens := encoding.Encode(v.text); !reflect.DeepEqual(tokens, v.tokens) {
+			t.Errorf("Encode(%q) = %v, want %v", v.text, tokens, v.tokens)
+		}
+	}
+
+	// Long pieces (priority queue merge) are the same of the linear merge
+	var piece = []byte(strings.Repeat("abcdefghij", 100))
+	if !reflect.DeepEqual(encoding.merge_queue(piece), encoding.merge_linear(piece)) {
+		t.Errorf("merge_queue of the long piece is not the same of merge_linear")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
@@ -10,19 +11,19 @@ import (
 )
 
 func BinaryToHexString(buffer []byte, max int) string {
-	length := len(buffer) 
-	if(length == 0) {
+	length := len(buffer)
+	if length == 0 {
 		return ""
 	}
 
-	if(max >= 0 && max <= length) {
+	if max >= 0 && max <= length {
 		length = max
 	}
 
 	text := ""
-	for i:= 0; i < length; i ++ {
+	for i := 0; i < length; i++ {
 		text += fmt.Sprintf("%02X", buffer[i])
-		if(i + 1 < length) {
+		if i+1 < length {
 			text += " "
 		}
 	}
@@ -55,3 +56,12 @@ func SHA256(text string) string {
 	}
 	return strings.ToUpper(hex.EncodeToString(hash.Sum(nil)))
 }
+
+// Random token (crypto/rand), 64 hex characters
+func GenerateToken() string {
+	var buffer = make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(buffer))
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"os"
+	"path/filepath"
 )
 
 // CERT Files
@@ -29,3 +30,23 @@ func LoadCertFromFiles(crt_filename string, key_filename string) *tls.Certificat
 	}
 	return &cert
 }
+
+// Write file, make parent dirs if not exists
+func WriteFile(filename string, data []byte) bool {
+	var dir = filepath.Dir(filename)
+	_, err := os.Stat(dir)
+	if err != nil && os.IsNotExist(err) {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			println("[Error] Make dirs (" + dir + ") error : " + err.Error())
+			return false
+		}
+	}
+
+	err = os.WriteFile(filename, data, 0644)
+	if err != nil {
+		println("[Error] Write file (" + filename + ") error : " + err.Error())
+		return false
+	}
+	return true
+}
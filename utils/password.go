@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// argon2id (RFC 9106, second recommended option)
+const (
+	PASSWORD_ARGON2_MEMORY  = 64 * 1024
+	PASSWORD_ARGON2_TIME    = 3
+	PASSWORD_ARGON2_THREADS = 4
+	PASSWORD_ARGON2_SALT    = 16
+	PASSWORD_ARGON2_KEY     = 32
+)
+
+// PHC string : $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func PasswordHash(password string) string {
+	var salt = make([]byte, PASSWORD_ARGON2_SALT)
+	if _, err := rand.Read(salt); err != nil {
+		return ""
+	}
+
+	var key = argon2.IDKey([]byte(password), salt,
+		PASSWORD_ARGON2_TIME, PASSWORD_ARGON2_MEMORY, PASSWORD_ARGON2_THREADS, PASSWORD_ARGON2_KEY)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		PASSWORD_ARGON2_MEMORY, PASSWORD_ARGON2_TIME, PASSWORD_ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// argon2id or bcrypt ($2a$, $2b$, $2y$) hash
+func PasswordVerify(password string, hash string) bool {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	var values = strings.Split(hash, "$")
+	if len(values) != 6 || values[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(values[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(values[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(values[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(values[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+
+	var other = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
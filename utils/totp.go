@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238) : HMAC-SHA1, 6 digits, 30 seconds
+const (
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30
+	// Steps before and after the current step (clock drift)
+	TOTP_SKEW = 1
+)
+
+var totp_encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Random secret (160 bits), base32 without padding
+func TOTPGenerateSecret() string {
+	var buffer = make([]byte, 20)
+	if _, err := rand.Read(buffer); err != nil {
+		return ""
+	}
+	return totp_encoding.EncodeToString(buffer)
+}
+
+// otpauth://totp/<issuer>:<account>?secret=...&issuer=...
+func TOTPURI(issuer string, account string, secret string) string {
+	var label = url.PathEscape(account)
+	if len(issuer) > 0 {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	var values = url.Values{}
+	values.Set("secret", secret)
+	if len(issuer) > 0 {
+		values.Set("issuer", issuer)
+	}
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	values.Set("period", fmt.Sprintf("%d", TOTP_PERIOD))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// Code of the step (RFC 4226 dynamic truncation)
+func TOTPCode(secret string, step int64) string {
+	key, err := totp_encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(key) == 0 {
+		return ""
+	}
+
+	var counter = make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	var mac = hmac.New(sha1.New, key)
+	mac.Write(counter)
+	var sum = mac.Sum(nil)
+
+	var offset = sum[len(sum)-1] & 0x0f
+	var value = binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	var mod uint32 = 1
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%mod)
+}
+
+// Step of the code, the steps not after last_step are refused (replay), -1 : invalidate
+func TOTPVerify(secret string, code string, last_step int64) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTP_DIGITS {
+		return -1
+	}
+
+	var step = TOTPStep(time.Now())
+	for i := -TOTP_SKEW; i <= TOTP_SKEW; i++ {
+		var value = TOTPCode(secret, step+int64(i))
+		if len(value) == 0 || step+int64(i) <= last_step {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(value), []byte(code)) == 1 {
+			return step + int64(i)
+		}
+	}
+	return -1
+}
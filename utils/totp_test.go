@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// Base32 of the RFC 6238 SHA1 seed "12345678901234567890"
+const totp_test_secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B (SHA1), the 6 last digits of the 8 digits codes
+func TestTOTPCode(t *testing.T) {
+	var tests = []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range tests {
+		var step = TOTPStep(time.Unix(v.time, 0))
+		if code := TOTPCode(totp_test_secret, step); code != v.code {
+			t.Errorf("TOTPCode(T=%d) = %q, want %q", v.time, code, v.code)
+		}
+	}
+
+	// Lower case and spaces are accepted, invalid base32 is not
+	if code := TOTPCode(" gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", 1); code != "287082" {
+		t.Errorf("TOTPCode(lower case) = %q", code)
+	}
+	if code := TOTPCode("not base32!", 1); code != "" {
+		t.Errorf("TOTPCode(invalid) = %q, want empty", code)
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	var step = TOTPStep(time.Now())
+	var code = TOTPCode(totp_test_secret, step)
+
+	var tests = []struct {
+		name      string
+		code      string
+		last_step int64
+		ok        bool
+	}{
+		{"current", code, 0, true},
+		{"spaces", code[0:3] + " " + code[3:], 0, true},
+		{"previous step (skew)", TOTPCode(totp_test_secret, step-1), 0, true},
+		{"next step (skew)", TOTPCode(totp_test_secret, step+1), 0, true},
+		{"out of the skew", TOTPCode(totp_test_secret, step-TOTP_SKEW-1), 0, false},
+		{"replay", code, step, false},
+		{"length", code[0:5], 0, false},
+	}
+	for _, v := range tests {
+		var result = TOTPVerify(totp_test_secret, v.code, v.last_step)
+		if (result >= 0) != v.ok {
+			t.Errorf("TOTPVerify(%s) = %d, want ok %v", v.name, result, v.ok)
+		}
+	}
+}
+
+func TestTOTPGenerateSecret(t *testing.T) {
+	var secret = TOTPGenerateSecret()
+	if len(secret) != 32 || len(TOTPCode(secret, 1)) != TOTP_DIGITS {
+		t.Errorf("TOTPGenerateSecret() = %q", secret)
+	}
+	if secret == TOTPGenerateSecret() {
+		t.Errorf("TOTPGenerateSecret() is not random")
+	}
+}